@@ -4,13 +4,13 @@ import (
 	"context"
 	"fetch_reel/engine"
 	"fetch_reel/engine/downloader"
-	"net/http"
+	"fmt"
+	"log"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -22,6 +22,7 @@ type App struct {
 	sniffer    *engine.Sniffer
 	downloader *downloader.Downloader
 	env        *engine.EnvResolver
+	settings   *engine.SettingsStore
 
 	isPinned   bool // 记录是否置顶
 	isExpanded bool
@@ -29,14 +30,22 @@ type App struct {
 
 func NewApp() *App {
 	env := engine.NewEnvResolver()
-	manager := engine.NewManager()
-	sniffer := engine.NewSniffer(manager, env)
-	dl := downloader.NewDownloader(manager, env)
+	settings := engine.NewSettingsStore()
+	store, err := engine.OpenTaskStore(settings.Get().StorageBackend, env.GetExeDir())
+	if err != nil {
+		log.Printf("打开存储后端失败，回退到 JSON: %v", err)
+		store, _ = engine.OpenTaskStore(engine.StoreJSON, env.GetExeDir())
+	}
+	manager := engine.NewManager(store, engine.NewStatsStore(filepath.Join(env.GetExeDir(), "stats.json")))
+	sniffer := engine.NewSniffer(manager, env, settings)
+	dl := downloader.NewDownloader(manager, env, settings, sniffer)
 
 	return &App{
 		manager:    manager,
 		sniffer:    sniffer,
 		downloader: dl,
+		env:        env,
+		settings:   settings,
 		isPinned:   true, // 默认置顶（与 main.go 一致）
 	}
 }
@@ -44,6 +53,28 @@ func NewApp() *App {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 	a.manager.SetContext(ctx)
+	a.settings.SetContext(ctx)
+
+	// 下载目录变更后立即创建，避免首次下载时才暴露权限问题
+	a.settings.OnChange(func(s engine.Settings) {
+		_ = os.MkdirAll(a.settings.DownloadDir(), 0755)
+	})
+}
+
+// shutdown 程序退出前落盘
+func (a *App) shutdown(ctx context.Context) {
+	a.sniffer.Close()
+	a.manager.Close()
+}
+
+// GetSettings 获取当前设置
+func (a *App) GetSettings() engine.Settings {
+	return a.settings.Get()
+}
+
+// UpdateSettings 校验并保存设置，立即对后续操作生效
+func (a *App) UpdateSettings(s engine.Settings) error {
+	return a.settings.Update(s)
 }
 
 // TogglePin 切换窗口置顶状态
@@ -68,60 +99,73 @@ func (a *App) SetExpanded(expand bool) {
 	}
 }
 
-// getURLFileName 解析 URL 获取净化后的文件名
-// 例子: "abc12345.ts?auth=123" -> "abc12345.ts"
-func (a *App) getURLFileName(rawURL string) string {
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return "video_stream"
+// buildFileName 根据模板生成相对下载目录的文件路径，不含扩展名
+// serverName 为 Content-Disposition 中的文件名，作为模板中 {name} 的取值；
+// 仅当未使用规则模板且全局模板为默认值时，服务器明确给出的文件名直接作为文件名
+func (a *App) buildFileName(ev engine.SniffEvent, taskID, serverName string) string {
+	vars := engine.NewFilenameVars(ev, taskID)
+	if serverName != "" {
+		vars.Name = strings.TrimSuffix(serverName, filepath.Ext(serverName))
+	}
+
+	tpl := a.settings.Get().FilenameTemplate
+	if rule := a.sniffer.GetRule(ev.RuleName); rule != nil && rule.FilenameTemplate != "" {
+		tpl = rule.FilenameTemplate
+	} else if serverName != "" && tpl == engine.DefaultFilenameTemplate {
+		tpl = "{name}"
+	}
+	name := engine.RenderFilename(tpl, vars)
+	if name == "" {
+		// 模板渲染为空（如页面无标题），依次回退到服务器/URL 文件名和任务 ID
+		name = engine.SanitizeFilename(vars.Name)
 	}
-	// path.Base 会处理 /path/to/file.mp4 -> file.mp4
-	// 同时由于 u.Path 已经不包含 ?query，所以净化自动完成
-	name := path.Base(u.Path)
-	if name == "" || name == "." || name == "/" {
-		return "video_stream"
+	if name == "" {
+		name = "video_" + vars.ID
 	}
 	return name
 }
 
 func (a *App) CreateDownloadTask(sniffEvent engine.SniffEvent) (*engine.VideoTask, error) {
-	// 1. 净化文件名
-	cleanName := a.getURLFileName(sniffEvent.Url)
-
-	// 如果 URL 没解析出好名字，才考虑用网页 Title
-	finalTitle := cleanName
-	if finalTitle == "video_stream" && sniffEvent.Title != "" {
-		finalTitle = a.sanitizeFilename(sniffEvent.Title)
+	if sniffEvent.Type == "dash" {
+		return nil, fmt.Errorf("暂不支持下载 DASH (mpd) 格式的视频")
 	}
+	taskID := uuid.New().String()
 
-	// 2. 预检资源
+	// 1. 预检资源：获取大小、服务器建议的文件名，并根据文件头纠正类型
+	info := engine.ProbeResource(sniffEvent.Url, sniffEvent.Headers, a.settings.Get().PreCheckTimeoutDuration())
+	if info.DetectedType != "" && info.DetectedType != sniffEvent.Type {
+		sniffEvent.Type = info.DetectedType
+	}
 	finalSize := sniffEvent.Size
-	finalSupport := sniffEvent.SupportRange
-	if finalSize <= 0 {
-		size, support := a.preCheckResource(sniffEvent.Url, sniffEvent.Headers)
-		finalSize = size
-		if !finalSupport {
-			finalSupport = support
-		}
+	if info.Size > 0 {
+		finalSize = info.Size
 	}
+	finalSupport := sniffEvent.SupportRange || info.SupportRange
+	if sniffEvent.Type == "hls" {
+		// 对 m3u8 探测到的是播放列表本身的大小，没有意义
+		finalSize = 0
+	}
+
+	// 2. 按模板生成文件名
+	fileName := a.buildFileName(sniffEvent, taskID, info.FileName)
 
-	// 3. 准备路径
-	exePath, _ := os.Executable()
-	downloadDir := filepath.Join(filepath.Dir(exePath), "Downloads")
+	// 3. 准备路径：临时文件始终位于下载目录，成品可由规则指定输出目录
+	downloadDir := a.settings.DownloadDir()
 	_ = os.MkdirAll(downloadDir, 0755)
+	rule := a.sniffer.GetRule(sniffEvent.RuleName)
+	outputDir := rule.ResolveOutputDir(downloadDir)
 
-	taskID := uuid.New().String()
 	tempDir := filepath.Join(downloadDir, ".temp", taskID)
-	savePath := filepath.Join(downloadDir, finalTitle)
-	// 确保有 .mp4 后缀
-	if !strings.HasSuffix(strings.ToLower(savePath), ".mp4") && !strings.HasSuffix(strings.ToLower(savePath), ".ts") {
-		savePath += ".mp4"
-	}
+	savePath := filepath.Join(outputDir, filepath.FromSlash(fileName))
+	_ = os.MkdirAll(filepath.Dir(savePath), 0755)
+	ext := a.pickExtension(sniffEvent, info)
+	savePath += ext
 
 	task := &engine.VideoTask{
 		ID:           taskID,
-		Title:        finalTitle,
+		Title:        filepath.Base(savePath[:len(savePath)-len(ext)]),
 		Url:          sniffEvent.Url,
+		FinalUrl:     info.FinalURL,
 		OriginUrl:    sniffEvent.OriginUrl,
 		TargetID:     sniffEvent.TargetID,
 		Type:         sniffEvent.Type,
@@ -129,8 +173,10 @@ func (a *App) CreateDownloadTask(sniffEvent engine.SniffEvent) (*engine.VideoTas
 		Size:         finalSize,
 		SupportRange: finalSupport,
 		Headers:      sniffEvent.Headers,
+		RuleCookie:   rule.InjectsHeader("Cookie"),
 		SavePath:     savePath,
 		TempDir:      tempDir,
+		Duration:     sniffEvent.Duration, // 嗅探时预解析的时长，下载开始解析列表后会更新
 	}
 
 	a.manager.AddTask(task)
@@ -142,6 +188,21 @@ func (a *App) CreateDownloadTask(sniffEvent engine.SniffEvent) (*engine.VideoTas
 func (a *App) StartDownload(taskID string) { a.downloader.Start(taskID) }
 func (a *App) StopDownload(taskID string)  { a.downloader.Stop(taskID) }
 
+// VerifyTask 深度校验任务的分片（含保留了分片文件的已完成任务），损坏的自动重新下载，已完成的任务随后重新合并
+func (a *App) VerifyTask(taskID string) string {
+	bad, err := a.downloader.Verify(taskID)
+	if err != nil {
+		return err.Error()
+	}
+	if bad == 0 {
+		return "校验通过"
+	}
+	if task := a.manager.GetTaskByID(taskID); task != nil && task.UnitsRetained {
+		return fmt.Sprintf("发现 %d 个损坏分片，正在重新下载并重新合并", bad)
+	}
+	return fmt.Sprintf("发现 %d 个损坏分片，正在重新下载", bad)
+}
+
 func (a *App) DeleteTask(taskID string) {
 	a.downloader.Stop(taskID)
 	task := a.manager.GetTaskByID(taskID)
@@ -152,28 +213,103 @@ func (a *App) DeleteTask(taskID string) {
 }
 
 func (a *App) UpdateTaskUrl(taskID string, newUrl string, newHeaders map[string]string) string {
-	task := a.manager.GetTaskByID(taskID)
-	if task == nil {
+	ok := a.manager.UpdateTask(taskID, func(t *engine.VideoTask) {
+		if t.Url != newUrl {
+			// 旧链接的重定向目标已不适用，下载与刷新 Cookie 都回到新链接
+			t.FinalUrl = ""
+		}
+		t.Url = newUrl
+		t.Headers = newHeaders
+	})
+	if !ok {
 		return "任务不存在"
 	}
-	task.Url = newUrl
-	task.Headers = newHeaders
-	a.manager.AddTask(task)
+	a.manager.Logf(taskID, engine.LogInfo, "重新绑定链接: %s", newUrl)
 	return "链接更新成功"
 }
 
 func (a *App) UpdateTaskClips(taskID string, clips []engine.TimeRange) {
-	task := a.manager.GetTaskByID(taskID)
-	if task != nil {
-		task.Clips = clips
-		a.manager.AddTask(task)
-	}
+	a.manager.UpdateTask(taskID, func(t *engine.VideoTask) {
+		t.Clips = clips
+	})
 }
 
 func (a *App) GetTasks() []*engine.VideoTask {
 	return a.manager.GetAllTasks()
 }
 
+// QueryTasks 按条件过滤、排序、分页查询任务
+func (a *App) QueryTasks(q engine.TaskQuery) engine.TaskPage {
+	return a.manager.QueryTasks(q)
+}
+
+// SetTaskTags 设置任务标签（整体替换，自动去除空白与重复）
+func (a *App) SetTaskTags(taskID string, tags []string) string {
+	clean := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		clean = append(clean, tag)
+	}
+	if !a.manager.UpdateTask(taskID, func(t *engine.VideoTask) { t.Tags = clean }) {
+		return "任务不存在"
+	}
+	return "标签已更新"
+}
+
+// GetTaskLog 返回任务的活动日志
+func (a *App) GetTaskLog(taskID string) []engine.TaskLogEntry {
+	return a.manager.GetTaskLog(taskID)
+}
+
+// ExportTaskLog 弹出保存对话框，把任务快照与活动日志导出为 JSON，用于问题反馈
+// 用户取消时返回空字符串
+func (a *App) ExportTaskLog(taskID string) string {
+	data, err := a.manager.ExportTaskLog(taskID)
+	if err != nil {
+		return err.Error()
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "导出任务日志",
+		DefaultFilename: fmt.Sprintf("fetchreel-task-%s.json", taskID),
+		Filters:         []runtime.FileFilter{{DisplayName: "JSON", Pattern: "*.json"}},
+	})
+	if err != nil {
+		return err.Error()
+	}
+	if path == "" {
+		return ""
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Sprintf("导出失败: %v", err)
+	}
+	return "已导出到 " + path
+}
+
+// GetGlobalStats 全局速度、任务计数与今日流量
+func (a *App) GetGlobalStats() engine.GlobalStats {
+	return a.manager.GetGlobalStats()
+}
+
+// GetTaskSpeedHistory 任务最近两分钟的速度采样，用于绘制速度曲线
+func (a *App) GetTaskSpeedHistory(taskID string) []float64 {
+	return a.manager.GetSpeedHistory(taskID)
+}
+
+// GetHostStats 按站点累计的下载统计
+func (a *App) GetHostStats() []engine.HostStats {
+	return a.manager.GetHostStats()
+}
+
+// GetStorageIssue 返回启动时加载任务列表遇到的问题（已从备份恢复、无法恢复等），无问题返回空
+func (a *App) GetStorageIssue() string {
+	return a.manager.GetLoadIssue()
+}
+
 func (a *App) StartBrowser() string {
 	if err := a.sniffer.StartBrowser(); err != nil {
 		return err.Error()
@@ -181,28 +317,94 @@ func (a *App) StartBrowser() string {
 	return "OK"
 }
 
+// AttachBrowser 附加到已开启远程调试的浏览器，endpoint 为 host:port 或 ws:// 地址
+func (a *App) AttachBrowser(endpoint string) string {
+	if err := a.sniffer.Attach(endpoint); err != nil {
+		return err.Error()
+	}
+	return "OK"
+}
+
+// DetachBrowser 断开与浏览器的调试连接，浏览器与标签页保持打开
+func (a *App) DetachBrowser() {
+	a.sniffer.Detach()
+}
+
+// StopSniffer 停止嗅探，closeBrowser 为 true 时同时关闭由本程序启动的浏览器
+func (a *App) StopSniffer(closeBrowser bool) {
+	a.sniffer.Stop(closeBrowser)
+}
+
+// GetSnifferState 当前嗅探连接状态，之后的变化通过 sniffer_state 事件推送
+func (a *App) GetSnifferState() engine.SnifferState {
+	return a.sniffer.GetState()
+}
+
+// GetSniffRules 当前生效的嗅探规则及加载问题，之后的变化（包括手工修改规则文件）通过 sniff_rules 事件推送
+func (a *App) GetSniffRules() engine.RuleSet {
+	return a.sniffer.ListRules()
+}
+
+// SaveSniffRule 校验并保存嗅探规则，originalName 为空表示新增，否则替换该名称的规则
+func (a *App) SaveSniffRule(rule engine.SniffRule, originalName string) error {
+	return a.sniffer.SaveRule(rule, originalName)
+}
+
+// DeleteSniffRule 删除嗅探规则
+func (a *App) DeleteSniffRule(name string) error {
+	return a.sniffer.DeleteRule(name)
+}
+
+// ReloadSniffRules 立即重新读取规则文件
+func (a *App) ReloadSniffRules() error {
+	return a.sniffer.ReloadRules()
+}
+
+// TestSniffRule 用给定的 URL、页面地址与请求头测试规则，不影响正在进行的嗅探
+func (a *App) TestSniffRule(req engine.RuleTestRequest) engine.RuleTestResult {
+	return a.sniffer.TestRule(req)
+}
+
+// GetInstalledBrowsers 列出系统中可用于嗅探的浏览器，供设置页选择
+func (a *App) GetInstalledBrowsers() []engine.BrowserInfo {
+	return engine.FindInstalledBrowsers()
+}
+
 func (a *App) OpenDownloadFolder() {
-	exePath, _ := os.Executable()
-	dir := filepath.Join(filepath.Dir(exePath), "Downloads")
+	dir := a.settings.DownloadDir()
 	_ = os.MkdirAll(dir, 0755)
 	runtime.BrowserOpenURL(a.ctx, dir)
 }
 
-func (a *App) preCheckResource(url string, headers map[string]string) (int64, bool) {
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, _ := http.NewRequest("HEAD", url, nil)
-	for k, v := range headers {
-		req.Header.Set(k, v)
+// pickExtension 决定输出文件的容器扩展名
+// HLS 由 FFmpeg 封装为 mp4；直链依次参考 Content-Disposition、Content-Type（预检优先，其次嗅探时的响应）、文件头、URL 后缀，最后兜底 .mp4
+func (a *App) pickExtension(ev engine.SniffEvent, info engine.ProbeResult) string {
+	if ev.Type == "hls" {
+		return ".mp4"
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, false
+	if ext := filepath.Ext(info.FileName); engine.IsMediaExt(ext) {
+		return strings.ToLower(ext)
+	}
+	if ext := engine.ExtFromContentType(info.ContentType); ext != "" {
+		return ext
+	}
+	if ext := engine.ExtFromContentType(ev.ContentType); ext != "" {
+		return ext
 	}
-	defer resp.Body.Close()
-	return resp.ContentLength, strings.Contains(strings.ToLower(resp.Header.Get("Accept-Ranges")), "bytes") || resp.StatusCode == 206
+	if info.DetectedExt != "" {
+		return info.DetectedExt
+	}
+	if ext := path.Ext(urlPath(ev.Url)); engine.IsMediaExt(ext) {
+		return strings.ToLower(ext)
+	}
+	return ".mp4"
 }
 
-func (a *App) sanitizeFilename(name string) string {
-	r := strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
-	return strings.TrimSpace(r.Replace(name))
+// urlPath 返回 URL 的路径部分（不含 query），解析失败返回空
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
 }
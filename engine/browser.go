@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BrowserInfo 找到的浏览器
+type BrowserInfo struct {
+	Name string `json:"name"` // chrome / chromium / edge / brave / custom / bundled
+	Path string `json:"path"`
+}
+
+// browserCandidate 某个浏览器在当前系统上的可能位置
+type browserCandidate struct {
+	name  string
+	paths []string // 绝对路径
+	bins  []string // 在 PATH 中查找的命令名
+}
+
+// browserCandidates 按优先级返回当前系统上的候选浏览器
+func browserCandidates() []browserCandidate {
+	switch runtime.GOOS {
+	case "windows":
+		roots := windowsProgramRoots()
+		join := func(parts ...string) []string {
+			var out []string
+			for _, root := range roots {
+				out = append(out, filepath.Join(append([]string{root}, parts...)...))
+			}
+			return out
+		}
+		return []browserCandidate{
+			{name: "edge", paths: join("Microsoft", "Edge", "Application", "msedge.exe")},
+			{name: "chrome", paths: join("Google", "Chrome", "Application", "chrome.exe")},
+			{name: "brave", paths: join("BraveSoftware", "Brave-Browser", "Application", "brave.exe")},
+			{name: "chromium", paths: join("Chromium", "Application", "chrome.exe")},
+		}
+	case "darwin":
+		app := func(bundle, bin string) []string {
+			p := filepath.Join("Applications", bundle+".app", "Contents", "MacOS", bin)
+			home, _ := os.UserHomeDir()
+			return []string{"/" + p, filepath.Join(home, p)}
+		}
+		return []browserCandidate{
+			{name: "chrome", paths: app("Google Chrome", "Google Chrome")},
+			{name: "edge", paths: app("Microsoft Edge", "Microsoft Edge")},
+			{name: "brave", paths: app("Brave Browser", "Brave Browser")},
+			{name: "chromium", paths: app("Chromium", "Chromium")},
+		}
+	default:
+		return []browserCandidate{
+			{name: "chrome", bins: []string{"google-chrome", "google-chrome-stable"}, paths: []string{"/opt/google/chrome/chrome"}},
+			{name: "chromium", bins: []string{"chromium", "chromium-browser"}, paths: []string{"/snap/bin/chromium"}},
+			{name: "edge", bins: []string{"microsoft-edge", "microsoft-edge-stable"}, paths: []string{"/opt/microsoft/msedge/msedge"}},
+			{name: "brave", bins: []string{"brave-browser", "brave"}, paths: []string{"/opt/brave.com/brave/brave"}},
+		}
+	}
+}
+
+// windowsProgramRoots 浏览器可能的安装根目录（系统级与用户级）
+func windowsProgramRoots() []string {
+	var roots []string
+	for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "LocalAppData"} {
+		if dir := os.Getenv(env); dir != "" {
+			roots = append(roots, dir)
+		}
+	}
+	if len(roots) == 0 {
+		roots = []string{`C:\Program Files`, `C:\Program Files (x86)`} // 备选兜底
+	}
+	return roots
+}
+
+// FindBrowser 定位可用于嗅探的浏览器
+// 顺序：用户指定路径 -> 程序自带 (bin/chrome) -> 系统安装的 Edge/Chrome/Brave/Chromium
+func (e *EnvResolver) FindBrowser(override string) (BrowserInfo, error) {
+	if override != "" {
+		if info, err := os.Stat(override); err != nil || info.IsDir() {
+			return BrowserInfo{}, fmt.Errorf("指定的浏览器不存在: %s", override)
+		}
+		return BrowserInfo{Name: "custom", Path: override}, nil
+	}
+	if path := e.GetChromePath(); path != "" {
+		return BrowserInfo{Name: "bundled", Path: path}, nil
+	}
+	if list := FindInstalledBrowsers(); len(list) > 0 {
+		return list[0], nil
+	}
+	return BrowserInfo{}, fmt.Errorf("找不到 Chrome/Edge/Brave/Chromium 浏览器，请在设置中指定浏览器路径")
+}
+
+// FindInstalledBrowsers 列出系统中已安装的浏览器，每种只返回第一个找到的位置
+func FindInstalledBrowsers() []BrowserInfo {
+	var found []BrowserInfo
+	for _, c := range browserCandidates() {
+		if path := c.locate(); path != "" {
+			found = append(found, BrowserInfo{Name: c.name, Path: path})
+		}
+	}
+	return found
+}
+
+func (c browserCandidate) locate() string {
+	for _, p := range c.paths {
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+	for _, bin := range c.bins {
+		if p, err := exec.LookPath(bin); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// browserDataDir 浏览器独立的用户数据目录，不同内核的配置互不兼容，按浏览器分开
+// Edge 沿用旧版本的 edge_data 目录，保留用户已有的登录状态
+func browserDataDir(baseDir, name string) string {
+	if name == "edge" {
+		return filepath.Join(baseDir, "edge_data")
+	}
+	return filepath.Join(baseDir, "browser_data", strings.ToLower(name))
+}
+
+// pickDebugPort 优先使用设置中的端口，被占用时由系统分配一个空闲端口
+func pickDebugPort(preferred int) (int, error) {
+	if l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", preferred)); err == nil {
+		l.Close()
+		return preferred, nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
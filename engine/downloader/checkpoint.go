@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"fetch_reel/engine"
+	"sync"
+	"time"
+)
+
+// checkpointer 合并高频的分片完成事件，按数量或时间节流后批量写入存储
+// 保证崩溃时最多丢失 every 个分片或 interval 时长内的完成记录
+type checkpointer struct {
+	manager  *engine.Manager
+	taskID   string
+	kind     engine.UnitKind
+	interval time.Duration
+	every    int
+
+	mu      sync.Mutex
+	pending []int
+	timer   *time.Timer
+}
+
+func newCheckpointer(m *engine.Manager, taskID string, kind engine.UnitKind, interval time.Duration, every int) *checkpointer {
+	return &checkpointer{manager: m, taskID: taskID, kind: kind, interval: interval, every: every}
+}
+
+// Mark 记录一个单元完成
+func (c *checkpointer) Mark(index int) {
+	c.mu.Lock()
+	c.pending = append(c.pending, index)
+	if len(c.pending) >= c.every {
+		c.mu.Unlock()
+		c.Flush()
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, c.Flush)
+	}
+	c.mu.Unlock()
+}
+
+// Flush 立即写入（没有待保存的记录时 MarkUnits 直接返回）
+func (c *checkpointer) Flush() {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	indexes := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	c.manager.MarkUnits(c.taskID, c.kind, indexes, true)
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"fetch_reel/engine"
+	"os"
+	"sync"
+)
+
+// hlsEstimator 估算 HLS 任务的总字节数
+// 优先按已完成分片的“字节/秒”乘以总时长（EXTINF 累加）外推；
+// 还没有完成的分片时用 BANDWIDTH × 总时长；两者都不可用时按分片数量平均
+type hlsEstimator struct {
+	mu            sync.Mutex
+	totalDuration float64 // 所有分片时长之和（秒）
+	totalCount    int
+	bandwidth     int64 // 声明码率 bit/s，0 表示未知
+
+	doneDuration float64
+	doneBytes    int64
+	doneCount    int
+}
+
+// newHLSEstimator 根据单元计划初始化，已完成分片的大小从磁盘读取
+func (d *Downloader) newHLSEstimator(task *engine.VideoTask) *hlsEstimator {
+	e := &hlsEstimator{bandwidth: task.Bandwidth}
+	for i := range task.InternalState.HLSSegments {
+		seg := &task.InternalState.HLSSegments[i]
+		e.totalDuration += seg.Duration
+		e.totalCount++
+		if !seg.IsFinished {
+			continue
+		}
+		if info, err := os.Stat(d.segmentPath(task, seg)); err == nil {
+			e.doneBytes += info.Size()
+			e.doneDuration += seg.Duration
+			e.doneCount++
+		}
+	}
+	return e
+}
+
+// add 记录一个新完成的分片
+func (e *hlsEstimator) add(duration float64, size int64) {
+	e.mu.Lock()
+	e.doneDuration += duration
+	e.doneBytes += size
+	e.doneCount++
+	e.mu.Unlock()
+}
+
+// downloaded 已完成分片的累计字节数
+func (e *hlsEstimator) downloaded() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.doneBytes
+}
+
+// estimate 返回预计总字节数，0 表示暂时无法估算
+func (e *hlsEstimator) estimate() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.doneCount >= e.totalCount && e.totalCount > 0:
+		return e.doneBytes
+	case e.doneDuration > 0 && e.totalDuration > 0:
+		return int64(float64(e.doneBytes) / e.doneDuration * e.totalDuration)
+	case e.bandwidth > 0 && e.totalDuration > 0:
+		return int64(float64(e.bandwidth) / 8 * e.totalDuration)
+	case e.doneCount > 0:
+		return e.doneBytes / int64(e.doneCount) * int64(e.totalCount)
+	}
+	return 0
+}
@@ -2,26 +2,33 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fetch_reel/engine"
+	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 	"sync"
 )
 
 type Downloader struct {
-	manager     *engine.Manager     // 引用全局任务管理器，用于更新 tasks.json
-	env         *engine.EnvResolver // 环境探测器
-	activeTasks sync.Map            // map[string]context.CancelFunc 存储正在运行的任务
+	manager     *engine.Manager       // 引用全局任务管理器，用于更新 tasks.json
+	env         *engine.EnvResolver   // 环境探测器
+	settings    *engine.SettingsStore // 全局设置（并发数、分块大小等）
+	cookies     engine.CookieProvider // 浏览器 Cookie，为空时只使用嗅探时记录的请求头
+	activeTasks sync.Map              // map[string]context.CancelFunc 存储正在运行的任务
 }
 
-func NewDownloader(m *engine.Manager, env *engine.EnvResolver) *Downloader {
+func NewDownloader(m *engine.Manager, env *engine.EnvResolver, settings *engine.SettingsStore, cookies engine.CookieProvider) *Downloader {
 	return &Downloader{
-		manager: m,
-		env:     env,
+		manager:  m,
+		env:      env,
+		settings: settings,
+		cookies:  cookies,
 	}
 }
 
 // Start 启动或恢复一个下载任务
+// 处理器操作的是任务快照（私有副本），状态变更一律通过 Manager 的方法回写
 func (d *Downloader) Start(taskID string) {
 	task := d.manager.GetTaskByID(taskID)
 	if task == nil {
@@ -43,6 +50,11 @@ func (d *Downloader) Start(taskID string) {
 		defer d.activeTasks.Delete(taskID)
 
 		d.manager.UpdateTaskStatus(taskID, "downloading")
+		if task.InternalState != nil {
+			done, total := task.InternalState.UnitProgress()
+			d.manager.Logf(taskID, engine.LogInfo, "继续下载: 已完成 %d/%d 个单元", done, total)
+		}
+		d.refreshCookies(task)
 
 		var err error
 		if task.Type == "mp4" {
@@ -55,12 +67,12 @@ func (d *Downloader) Start(taskID string) {
 		select {
 		case <-ctx.Done():
 			// 只有在非错误导致结束时，才标记为暂停
-			if task.Status != "error" {
+			if cur := d.manager.GetTaskByID(taskID); cur != nil && cur.Status != "error" {
 				d.manager.UpdateTaskStatus(taskID, "paused")
 			}
 		default:
 			if err != nil {
-				d.manager.UpdateTaskStatus(taskID, "error")
+				d.manager.FailTask(taskID, err)
 			} else {
 				// 执行合并逻辑（在具体的处理器里完成下载后调用）
 				d.manager.UpdateTaskStatus(taskID, "done")
@@ -69,6 +81,48 @@ func (d *Downloader) Start(taskID string) {
 	}()
 }
 
+// refreshCookies 开始或继续下载前从浏览器读取最新的 Cookie，替换嗅探时记录的（可能已过期）
+// 浏览器未连接或读取失败时沿用原有请求头；规则固定指定的 Cookie 优先，不刷新
+func (d *Downloader) refreshCookies(task *engine.VideoTask) {
+	if d.cookies == nil || task.RuleCookie {
+		return
+	}
+	urls := []string{task.Url}
+	if task.FinalUrl != "" && task.FinalUrl != task.Url {
+		urls = append(urls, task.FinalUrl)
+	}
+	cookie, err := d.cookies.CookieHeader(task.TargetID, urls...)
+	if err != nil {
+		if !errors.Is(err, engine.ErrSnifferNotConnected) {
+			d.manager.Logf(task.ID, engine.LogWarn, "读取浏览器 Cookie 失败，沿用原有请求头: %v", err)
+		}
+		return
+	}
+	if cookie == "" || cookie == task.Headers["Cookie"] {
+		return
+	}
+
+	task.Headers = withCookie(task.Headers, cookie)
+	d.manager.UpdateTask(task.ID, func(t *engine.VideoTask) {
+		t.Headers = withCookie(t.Headers, cookie)
+	})
+	d.manager.Logf(task.ID, engine.LogInfo, "已从浏览器更新 Cookie")
+}
+
+// withCookie 设置 Cookie 头，规则按原样大小写记录的同名头（如 cookie）一并替换
+func withCookie(headers map[string]string, cookie string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	for k := range headers {
+		if strings.EqualFold(k, "Cookie") {
+			delete(headers, k)
+		}
+	}
+	headers["Cookie"] = cookie
+	return headers
+}
+
 // Stop 暂停任务
 func (d *Downloader) Stop(taskID string) {
 	if cancel, ok := d.activeTasks.Load(taskID); ok {
@@ -77,25 +131,19 @@ func (d *Downloader) Stop(taskID string) {
 	}
 }
 
-// RefreshProgress 基于“真理源”计算进度
-// 被具体的下载处理器高频调用，但不触发磁盘写入
-func (d *Downloader) RefreshProgress(taskID string, speed string) {
-	task := d.manager.GetTaskByID(taskID)
-	if task == nil {
-		return
-	}
-
-	// 扫描临时目录下的所有文件，累加大小
-	var downloadedSize int64
-	_ = filepath.Walk(task.TempDir, func(path string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() {
-			downloadedSize += info.Size()
+// doRequest 发送请求并把请求与响应状态记入任务日志
+func (d *Downloader) doRequest(taskID string, req *http.Request) (*http.Response, error) {
+	d.manager.Logf(taskID, engine.LogDebug, "请求 %s", engine.FormatRequestForLog(req))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			d.manager.Logf(taskID, engine.LogWarn, "请求失败 %s: %v", req.URL, err)
 		}
-		return nil
-	})
-
-	// 调用全局 Manager 更新内存状态和前端事件
-	d.manager.UpdateTaskProgress(taskID, downloadedSize, speed)
+		return nil, err
+	}
+	d.manager.Logf(taskID, engine.LogDebug, "响应 %s (Content-Length=%d, Content-Type=%s) %s",
+		resp.Status, resp.ContentLength, resp.Header.Get("Content-Type"), req.URL)
+	return resp, nil
 }
 
 // GetFFmpegPath 从环境探测器获取路径
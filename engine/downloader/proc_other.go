@@ -0,0 +1,8 @@
+//go:build !windows
+
+package downloader
+
+import "os/exec"
+
+// hideWindow 非 Windows 系统启动子进程不会弹出控制台窗口，无需处理
+func hideWindow(cmd *exec.Cmd) {}
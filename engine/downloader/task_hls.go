@@ -12,7 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/grafov/m3u8"
 )
@@ -27,16 +27,45 @@ func (d *Downloader) processHLS(ctx context.Context, task *engine.VideoTask) err
 	}
 
 	// 2. 并发下载 TS 分片
-	sem := make(chan struct{}, 3)
+	if err := d.downloadHLSSegments(ctx, task); err != nil {
+		return err
+	}
+
+	// 3. 可选深度校验：损坏的分片会被重置并重新下载
+	verified := d.needsDeepVerify(task)
+	if verified {
+		if err := d.repairUntilValid(ctx, task, d.verifyHLSSegments, d.downloadHLSSegments); err != nil {
+			return err
+		}
+	}
+
+	// 4. 调用 FFmpeg 合并
+	return d.mergeHLSSegments(task, verified)
+}
+
+// downloadHLSSegments 并发下载所有未完成的分片
+func (d *Downloader) downloadHLSSegments(ctx context.Context, task *engine.VideoTask) error {
+	sem := make(chan struct{}, d.settings.Get().MaxConcurrency)
 	var wg sync.WaitGroup
 	errChan := make(chan error, 1)
 
-	total := len(task.InternalState.HLSSegments)
+	// 已完成分片的大小与时长用于估算总大小，内部加锁，可在协程间共享
+	est := d.newHLSEstimator(task)
+	d.updateHLSProgress(task, est)
+
+	// 分片完成状态按 10 个或 2 秒节流写盘，退出时（含暂停/出错）再补写一次
+	cp := newCheckpointer(d.manager, task.ID, engine.UnitHLSSegment, 2*time.Second, 10)
+	defer cp.Flush()
 
 	for i := range task.InternalState.HLSSegments {
 		seg := &task.InternalState.HLSSegments[i]
 		if seg.IsFinished {
-			continue
+			// 记录已完成但文件丢失（如被手动清理），需要重新下载
+			if _, err := os.Stat(d.segmentPath(task, seg)); err == nil {
+				continue
+			}
+			seg.IsFinished = false
+			d.manager.MarkUnits(task.ID, engine.UnitHLSSegment, []int{seg.Index}, false)
 		}
 
 		select {
@@ -50,14 +79,16 @@ func (d *Downloader) processHLS(ctx context.Context, task *engine.VideoTask) err
 				defer wg.Done()
 				defer func() { <-sem }()
 
-				if err := d.downloadTSSegment(ctx, task, s); err != nil {
+				size, err := d.downloadTSSegment(ctx, task, s)
+				if err != nil {
 					select {
-					case errChan <- err:
+					case errChan <- engine.WithUnit(err, s.Index):
 					default:
 					}
 				} else {
-					// 下载完一个分片，更新进度百分比（基于数量）
-					d.updateHLSProgress(task, total)
+					cp.Mark(s.Index)
+					est.add(s.Duration, size)
+					d.updateHLSProgress(task, est)
 				}
 			}(seg)
 		}
@@ -70,44 +101,85 @@ func (d *Downloader) processHLS(ctx context.Context, task *engine.VideoTask) err
 		return err
 	default:
 	}
-
-	// 3. 调用 FFmpeg 合并
-	return d.mergeHLSSegments(task)
+	return nil
 }
 
-// prepareHLSSegments 请求并解析 m3u8
-func (d *Downloader) prepareHLSSegments(ctx context.Context, task *engine.VideoTask) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", task.Url, nil)
+// fetchPlaylist 请求并解析一个 m3u8
+func (d *Downloader) fetchPlaylist(ctx context.Context, task *engine.VideoTask, playlistURL string) (m3u8.Playlist, m3u8.ListType, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL, nil)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	for k, v := range task.Headers {
 		req.Header.Set(k, v)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.doRequest(task.ID, req)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, 0, err
+	}
 
 	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return nil, 0, engine.NewTaskError(engine.ErrCodePlaylist, "解析 m3u8 失败: %v", err)
+	}
+	return playlist, listType, nil
+}
+
+// prepareHLSSegments 请求并解析 m3u8
+// 如果是 Master Playlist，选择码率最高的变体，并记录其 BANDWIDTH 用于估算大小
+func (d *Downloader) prepareHLSSegments(ctx context.Context, task *engine.VideoTask) error {
+	playlistURL := task.Url
+	playlist, listType, err := d.fetchPlaylist(ctx, task, playlistURL)
 	if err != nil {
 		return err
 	}
 
+	var bandwidth int64
+	if listType == m3u8.MASTER {
+		variant := bestVariant(playlist.(*m3u8.MasterPlaylist))
+		if variant == nil {
+			return engine.NewTaskError(engine.ErrCodePlaylist, "Master Playlist 中没有可用的变体")
+		}
+		base, _ := url.Parse(playlistURL)
+		u, err := url.Parse(variant.URI)
+		if err != nil {
+			return engine.NewTaskError(engine.ErrCodePlaylist, "变体地址无效: %v", err)
+		}
+		playlistURL = base.ResolveReference(u).String()
+		// BANDWIDTH 是峰值码率，有 AVERAGE-BANDWIDTH 时优先用平均值估算
+		bandwidth = int64(variant.Bandwidth)
+		if variant.AverageBandwidth > 0 {
+			bandwidth = int64(variant.AverageBandwidth)
+		}
+		d.manager.Logf(task.ID, engine.LogInfo, "选择变体 BANDWIDTH=%d RESOLUTION=%s: %s", variant.Bandwidth, variant.Resolution, playlistURL)
+
+		if playlist, listType, err = d.fetchPlaylist(ctx, task, playlistURL); err != nil {
+			return err
+		}
+	}
 	if listType != m3u8.MEDIA {
-		return fmt.Errorf("不支持的 m3u8 类型 (可能是 Master Playlist)")
+		return engine.NewTaskError(engine.ErrCodePlaylist, "不支持的 m3u8 类型")
 	}
 
 	mediaList := playlist.(*m3u8.MediaPlaylist)
 	var segments []engine.HLSSegmentState
-	baseURL, _ := url.Parse(task.Url)
+	var totalDuration float64
+	baseURL, _ := url.Parse(playlistURL)
 
+	// EXT-X-KEY 对其后的所有分片生效，直到出现新的 KEY
+	key := mediaList.Key
 	for i, seg := range mediaList.Segments {
 		if seg == nil {
 			continue
 		}
+		if seg.Key != nil {
+			key = seg.Key
+		}
 		// 处理相对路径
 		u, _ := url.Parse(seg.URI)
 		fullURL := baseURL.ResolveReference(u).String()
@@ -116,80 +188,116 @@ func (d *Downloader) prepareHLSSegments(ctx context.Context, task *engine.VideoT
 			Index:      i,
 			URL:        fullURL,
 			IsFinished: false,
+			Encrypted:  key != nil && key.Method != "" && !strings.EqualFold(key.Method, "NONE"),
+			Duration:   seg.Duration,
 		})
+		totalDuration += seg.Duration
 	}
 
+	task.Duration = totalDuration
+	task.Bandwidth = bandwidth
+	d.manager.UpdateTask(task.ID, func(t *engine.VideoTask) {
+		t.Duration = totalDuration
+		t.Bandwidth = bandwidth
+	})
 	task.InternalState = &engine.TaskInternalState{HLSSegments: segments}
-	d.manager.AddTask(task)
+	d.manager.SetInternalState(task.ID, task.InternalState)
 	return nil
 }
 
-// downloadTSSegment 下载单个 TS
-func (d *Downloader) downloadTSSegment(ctx context.Context, task *engine.VideoTask, seg *engine.HLSSegmentState) error {
-	tsPath := filepath.Join(task.TempDir, fmt.Sprintf("seg_%05d.ts", seg.Index))
+// bestVariant 选择码率最高的变体（忽略仅含 I 帧的变体）
+func bestVariant(master *m3u8.MasterPlaylist) *m3u8.Variant {
+	var best *m3u8.Variant
+	for _, v := range master.Variants {
+		if v == nil || v.Iframe || v.URI == "" {
+			continue
+		}
+		if best == nil || v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+	return best
+}
+
+// segmentPath 分片的最终文件路径
+func (d *Downloader) segmentPath(task *engine.VideoTask, seg *engine.HLSSegmentState) string {
+	return filepath.Join(task.TempDir, fmt.Sprintf("seg_%05d.ts", seg.Index))
+}
+
+// downloadTSSegment 下载单个 TS，返回分片文件的大小
+// 先写入 .part 临时文件，完整下载后再重命名，保证最终文件名存在即代表分片完整
+func (d *Downloader) downloadTSSegment(ctx context.Context, task *engine.VideoTask, seg *engine.HLSSegmentState) (int64, error) {
+	tsPath := d.segmentPath(task, seg)
 
-	// 真理源检查：如果文件已存在且大小正常，则跳过
-	// 注意：由于 TS 很小，我们不处理 TS 内部的断点续传，不完整直接重下
-	if f, err := os.Stat(tsPath); err == nil && f.Size() > 0 {
+	// 真理源检查：最终文件只会由重命名产生，存在即完整
+	// 注意：由于 TS 很小，我们不处理 TS 内部的断点续传，残留的 .part 直接覆盖重下
+	if info, err := os.Stat(tsPath); err == nil {
 		seg.IsFinished = true
-		return nil
+		return info.Size(), nil
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", seg.URL, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	for k, v := range task.Headers {
 		req.Header.Set(k, v)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.doRequest(task.ID, req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
-	out, err := os.Create(tsPath)
+	if err := checkResponse(resp); err != nil {
+		return 0, err
+	}
+
+	partPath := tsPath + ".part"
+	out, err := os.Create(partPath)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	written, err := io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkBodyLength(resp, written)
+	}
+	if err == nil {
+		err = verifyTSFile(partPath, seg.Encrypted, false)
+	}
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if err := os.Rename(partPath, tsPath); err != nil {
+		return 0, err
 	}
 
 	seg.IsFinished = true
-	// 每完成一个 TS，不一定非要存盘 tasks.json（太频繁），
-	// 我们可以在每完成 10 个分片时存一次，或者在下载循环中处理
-	return nil
+	return written, nil
 }
 
-// updateHLSProgress 计算基于数量的进度
-func (d *Downloader) updateHLSProgress(task *engine.VideoTask, total int) {
-	finished := 0
-	for _, s := range task.InternalState.HLSSegments {
-		if s.IsFinished {
-			finished++
-		}
+// updateHLSProgress 更新估算总大小并刷新按字节计算的进度
+// 已下载字节数取自估算器中已完成分片的累计大小，不扫描临时目录（其中还有未完成的 .part 与 concat.txt）
+func (d *Downloader) updateHLSProgress(task *engine.VideoTask, est *hlsEstimator) {
+	if size := est.estimate(); size > 0 {
+		d.manager.SetEstimatedSize(task.ID, size)
 	}
-
-	// 更新内存中的进度百分比
-	progress := float64(finished) / float64(total) * 100
-	task.Progress = progress
-
-	// 同时触发真理源大小统计和速度计算
-	d.RefreshProgress(task.ID, "下载中...")
+	d.manager.UpdateTaskProgress(task.ID, est.downloaded(), "")
 }
 
 // mergeHLSSegments 使用 FFmpeg 合并 TS
-func (d *Downloader) mergeHLSSegments(task *engine.VideoTask) error {
+func (d *Downloader) mergeHLSSegments(task *engine.VideoTask, verified bool) error {
 	d.manager.UpdateTaskStatus(task.ID, "merging")
 
 	ffmpegPath := d.GetFFmpegPath()
 	if ffmpegPath == "" {
-		return fmt.Errorf("找不到 FFmpeg")
+		return engine.NewTaskError(engine.ErrCodeFFmpegMissing, "找不到 FFmpeg")
 	}
 
 	// 1. 生成 concat.txt
@@ -203,7 +311,7 @@ func (d *Downloader) mergeHLSSegments(task *engine.VideoTask) error {
 	_ = os.WriteFile(listPath, []byte(sb.String()), 0644)
 
 	// 2. 自动重名处理
-	finalPath := d.resolveFinalPath(task.SavePath)
+	finalPath := d.mergeTarget(task)
 
 	// 3. 执行 FFmpeg (隐藏窗口)
 	args := []string{
@@ -214,17 +322,45 @@ func (d *Downloader) mergeHLSSegments(task *engine.VideoTask) error {
 	cmd := exec.Command(ffmpegPath, args...)
 	cmd.Dir = task.TempDir // 在临时目录执行，简化 concat.txt 里的路径
 
-	// Windows 隐藏窗口关键设置
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow:    true,
-		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
-	}
+	hideWindow(cmd)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("FFmpeg 合并失败: %v", err)
+	d.manager.Logf(task.ID, engine.LogInfo, "执行 FFmpeg: %s %s (dir=%s)", ffmpegPath, strings.Join(args, " "), task.TempDir)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		d.manager.Logf(task.ID, engine.LogDebug, "FFmpeg 输出:%s", ffmpegTail(out, 40))
+	}
+	if err != nil {
+		return engine.NewTaskError(engine.ErrCodeFFmpegFailed, "FFmpeg 合并失败: %v%s", err, ffmpegTail(out, 5))
 	}
 
 	task.SavePath = finalPath
-	_ = os.RemoveAll(task.TempDir)
+	var finalSize int64
+	if info, err := os.Stat(finalPath); err == nil {
+		finalSize = info.Size()
+	}
+	d.manager.UpdateTask(task.ID, func(t *engine.VideoTask) {
+		t.SavePath = finalPath
+		// 合并完成后用实际文件大小替换估算值
+		if finalSize > 0 {
+			t.Size = finalSize
+			t.Downloaded = finalSize
+			t.Progress = 100
+			t.SizeEstimated = false
+		}
+	})
+	_ = os.Remove(listPath)
+	d.finishMerge(task, verified)
 	return nil
 }
+
+// ffmpegTail 截取 FFmpeg 输出的最后 n 行
+func ffmpegTail(out []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 // processMP4 处理 MP4 类型视频的下载
@@ -18,10 +19,30 @@ func (d *Downloader) processMP4(ctx context.Context, task *engine.VideoTask) err
 		d.prepareMP4Chunks(task)
 	}
 
-	// 2. 并发控制：最多 3 个协程
-	sem := make(chan struct{}, 3)
+	// 2. 下载所有未完成的分块
+	if err := d.downloadMP4Chunks(ctx, task); err != nil {
+		return err
+	}
+
+	// 3. 可选深度校验：损坏的分块会被重置并重新下载
+	verified := d.needsDeepVerify(task)
+	if verified {
+		if err := d.repairUntilValid(ctx, task, d.verifyMP4Chunks, d.downloadMP4Chunks); err != nil {
+			return err
+		}
+	}
+
+	// 4. 所有分片完成后，执行合并
+	return d.mergeMP4Chunks(task, verified)
+}
+
+// downloadMP4Chunks 并发下载所有未完成的分块
+func (d *Downloader) downloadMP4Chunks(ctx context.Context, task *engine.VideoTask) error {
+	// 并发控制：协程数由设置决定
+	sem := make(chan struct{}, d.settings.Get().MaxConcurrency)
 	var wg sync.WaitGroup
 	errChan := make(chan error, 1)
+	progress := newChunkProgress(d, task)
 
 	for i := range task.InternalState.MP4Chunks {
 		chunk := &task.InternalState.MP4Chunks[i]
@@ -40,9 +61,9 @@ func (d *Downloader) processMP4(ctx context.Context, task *engine.VideoTask) err
 				defer wg.Done()
 				defer func() { <-sem }() // 释放槽位
 
-				if err := d.downloadMP4Chunk(ctx, task, c); err != nil {
+				if err := d.downloadMP4Chunk(ctx, task, c, progress); err != nil {
 					select {
-					case errChan <- err:
+					case errChan <- engine.WithUnit(err, c.Index):
 					default:
 					}
 				}
@@ -58,14 +79,12 @@ func (d *Downloader) processMP4(ctx context.Context, task *engine.VideoTask) err
 		return err
 	default:
 	}
-
-	// 3. 所有分片完成后，执行合并
-	return d.mergeMP4Chunks(task)
+	return nil
 }
 
-// prepareMP4Chunks 划分 50MB 分块
+// prepareMP4Chunks 按设置中的分块大小划分
 func (d *Downloader) prepareMP4Chunks(task *engine.VideoTask) {
-	const chunkSize = 50 * 1024 * 1024 // 50MB
+	chunkSize := d.settings.Get().ChunkSizeBytes()
 	var chunks []engine.MP4ChunkState
 
 	if task.Size <= 0 || !task.SupportRange {
@@ -86,12 +105,45 @@ func (d *Downloader) prepareMP4Chunks(task *engine.VideoTask) {
 	}
 
 	task.InternalState = &engine.TaskInternalState{MP4Chunks: chunks}
-	d.manager.AddTask(task) // 触发持久化
+	d.manager.SetInternalState(task.ID, task.InternalState) // 触发持久化
+}
+
+// chunkProgress 各分块已下载的字节数
+// 仅在开始（或续传）时读取一次分块文件大小，下载过程中只更新内存计数，不再扫描临时目录
+type chunkProgress struct {
+	bytes []int64 // 按分块序号，原子读写
+}
+
+func newChunkProgress(d *Downloader, task *engine.VideoTask) *chunkProgress {
+	chunks := task.InternalState.MP4Chunks
+	p := &chunkProgress{bytes: make([]int64, len(chunks))}
+	for i := range chunks {
+		if info, err := os.Stat(d.chunkPath(task, &chunks[i])); err == nil {
+			p.bytes[i] = info.Size()
+		}
+	}
+	return p
+}
+
+func (p *chunkProgress) set(i int, n int64) { atomic.StoreInt64(&p.bytes[i], n) }
+func (p *chunkProgress) add(i int, n int64) { atomic.AddInt64(&p.bytes[i], n) }
+
+func (p *chunkProgress) total() int64 {
+	var sum int64
+	for i := range p.bytes {
+		sum += atomic.LoadInt64(&p.bytes[i])
+	}
+	return sum
+}
+
+// chunkPath 分块的本地文件路径
+func (d *Downloader) chunkPath(task *engine.VideoTask, chunk *engine.MP4ChunkState) string {
+	return filepath.Join(task.TempDir, fmt.Sprintf("part_%d.mp4", chunk.Index))
 }
 
 // downloadMP4Chunk 下载具体的单个分片
-func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTask, chunk *engine.MP4ChunkState) error {
-	partPath := filepath.Join(task.TempDir, fmt.Sprintf("part_%d.mp4", chunk.Index))
+func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTask, chunk *engine.MP4ChunkState, progress *chunkProgress) error {
+	partPath := d.chunkPath(task, chunk)
 
 	// 真理源检查：获取本地已下载大小
 	var startPos int64 = chunk.Start
@@ -99,10 +151,16 @@ func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTas
 	if f != nil {
 		if chunk.End != -1 && f.Size() >= (chunk.End-chunk.Start+1) {
 			chunk.IsFinished = true
+			d.manager.MarkUnits(task.ID, engine.UnitMP4Chunk, []int{chunk.Index}, true)
 			return nil
 		}
 		startPos += f.Size()
 	}
+	rangeSent := task.SupportRange
+	if !rangeSent {
+		// 不支持 Range 时已有的部分数据无法续传，只能从头下载
+		startPos = chunk.Start
+	}
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, "GET", task.Url, nil)
@@ -114,7 +172,7 @@ func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTas
 	for k, v := range task.Headers {
 		req.Header.Set(k, v)
 	}
-	if task.SupportRange {
+	if rangeSent {
 		rangeHeader := fmt.Sprintf("bytes=%d-", startPos)
 		if chunk.End != -1 {
 			rangeHeader += fmt.Sprintf("%d", chunk.End)
@@ -122,24 +180,38 @@ func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTas
 		req.Header.Set("Range", rangeHeader)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.doRequest(task.ID, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("服务器响应异常: %s", resp.Status)
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	// 服务器忽略 Range 返回了完整文件：分块覆盖整个文件时丢弃已有数据从头写入，否则无法使用
+	if rangeSent && resp.StatusCode == http.StatusOK && startPos != chunk.Start {
+		if chunk.Start != 0 || (chunk.End != -1 && chunk.End != task.Size-1) {
+			return engine.NewTaskError(engine.ErrCodeRangeUnsupported, "服务器未按 Range 返回数据，无法续传分块 %d", chunk.Index)
+		}
+		d.manager.Logf(task.ID, engine.LogWarn, "服务器未按 Range 返回数据，分块 %d 从头下载", chunk.Index)
+		startPos = chunk.Start
 	}
 
-	// 写入文件（断点续传模式）
-	out, err := os.OpenFile(partPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// 写入文件：从分块起点开始时清空旧数据，否则追加（断点续传）
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if startPos == chunk.Start {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
+	progress.set(chunk.Index, startPos-chunk.Start)
 
 	// 实时进度更新逻辑（不存盘）
+	var written int64
 	buf := make([]byte, 32*1024)
 	for {
 		n, readErr := resp.Body.Read(buf)
@@ -148,8 +220,9 @@ func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTas
 			if writeErr != nil {
 				return writeErr
 			}
-			// 调用真理源进度统计（由 manager.go 提供）
-			d.RefreshProgress(task.ID, "计算中...")
+			written += int64(n)
+			progress.add(chunk.Index, int64(n))
+			d.manager.UpdateTaskProgress(task.ID, progress.total(), "")
 		}
 		if readErr != nil {
 			if readErr == io.EOF {
@@ -159,17 +232,27 @@ func (d *Downloader) downloadMP4Chunk(ctx context.Context, task *engine.VideoTas
 		}
 	}
 
+	// 截断的响应体保留在分块文件中：支持 Range 时下次从断点继续，否则下次从头下载
+	if err := checkBodyLength(resp, written); err != nil {
+		return err
+	}
+	if chunk.End != -1 {
+		if info, err := out.Stat(); err == nil && info.Size() != chunk.End-chunk.Start+1 {
+			return engine.NewTaskError(engine.ErrCodeIntegrity, "分块 %d 大小不符: 期望 %d 字节，实际 %d 字节", chunk.Index, chunk.End-chunk.Start+1, info.Size())
+		}
+	}
+
 	chunk.IsFinished = true
-	d.manager.AddTask(task) // 分片完成，保存一次状态
+	d.manager.MarkUnits(task.ID, engine.UnitMP4Chunk, []int{chunk.Index}, true) // 分片完成，保存一次状态
 	return nil
 }
 
 // mergeMP4Chunks 物理合并分片
-func (d *Downloader) mergeMP4Chunks(task *engine.VideoTask) error {
+func (d *Downloader) mergeMP4Chunks(task *engine.VideoTask, verified bool) error {
 	d.manager.UpdateTaskStatus(task.ID, "merging")
 
 	// 处理文件名冲突逻辑 (Title (1).mp4)
-	finalPath := d.resolveFinalPath(task.SavePath)
+	finalPath := d.mergeTarget(task)
 
 	dest, err := os.Create(finalPath)
 	if err != nil {
@@ -190,8 +273,10 @@ func (d *Downloader) mergeMP4Chunks(task *engine.VideoTask) error {
 		}
 	}
 
-	task.SavePath = finalPath      // 更新可能的自动编号路径
-	_ = os.RemoveAll(task.TempDir) // 合并成功，清理临时目录
+	// 更新可能的自动编号路径
+	task.SavePath = finalPath
+	d.manager.UpdateTask(task.ID, func(t *engine.VideoTask) { t.SavePath = finalPath })
+	d.finishMerge(task, verified)
 	return nil
 }
 
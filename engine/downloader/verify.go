@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fetch_reel/engine"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+
+	// maxRepairPasses 深度校验发现坏分片后最多重下的轮数
+	maxRepairPasses = 2
+)
+
+// checkResponse 校验分片响应：状态码必须是 200/206，且不能是 HTML 错误页
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return engine.NewHTTPError(resp.StatusCode, resp.Status)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType == "text/html" {
+		return engine.NewTaskError(engine.ErrCodeHTMLResponse, "服务器返回了 HTML 页面而不是媒体数据 (%s)", resp.Status)
+	}
+	return nil
+}
+
+// checkBodyLength 校验实际写入的字节数与 Content-Length 一致（未声明长度时跳过）
+func checkBodyLength(resp *http.Response, written int64) error {
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return engine.NewTaskError(engine.ErrCodeTruncated, "响应体不完整: 期望 %d 字节，实际 %d 字节", resp.ContentLength, written)
+	}
+	return nil
+}
+
+// looksLikeHTML 判断文件头是否为 HTML/XML 文本（常见的 CDN 错误页以 200 返回）
+func looksLikeHTML(head []byte) bool {
+	trimmed := bytes.ToLower(bytes.TrimLeft(head, " \t\r\n\xEF\xBB\xBF"))
+	return bytes.HasPrefix(trimmed, []byte("<!doctype")) || bytes.HasPrefix(trimmed, []byte("<html")) || bytes.HasPrefix(trimmed, []byte("<?xml"))
+}
+
+// verifyTSFile 校验 TS 分片
+// 浅校验只看第一个包的同步字节；深校验检查每个 188 字节包的同步字节
+// fMP4 分片（ftyp/styp/moof 开头）和加密分片无法按 TS 校验，只排除 HTML
+func verifyTSFile(path string, encrypted, deep bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, tsPacketSize+1)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return engine.NewTaskError(engine.ErrCodeIntegrity, "分片为空")
+	}
+	head = head[:n]
+
+	if looksLikeHTML(head) {
+		return engine.NewTaskError(engine.ErrCodeHTMLResponse, "分片内容是 HTML 页面")
+	}
+	if encrypted {
+		return nil
+	}
+	if n >= 8 {
+		switch string(head[4:8]) {
+		case "ftyp", "styp", "moof":
+			return nil
+		}
+	}
+	if head[0] != tsSyncByte || (n > tsPacketSize && head[tsPacketSize] != tsSyncByte) {
+		return engine.NewTaskError(engine.ErrCodeIntegrity, "分片不是有效的 TS 数据 (同步字节错误)")
+	}
+	if !deep {
+		return nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	packet := make([]byte, tsPacketSize)
+	for offset := int64(0); ; offset += tsPacketSize {
+		n, err := io.ReadFull(f, packet)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return engine.NewTaskError(engine.ErrCodeTruncated, "分片在 %d 字节处截断", offset+int64(n))
+		}
+		if err != nil {
+			return err
+		}
+		if packet[0] != tsSyncByte {
+			return engine.NewTaskError(engine.ErrCodeIntegrity, "分片在 %d 字节处同步字节错误", offset)
+		}
+	}
+}
+
+// verifyHLSSegments 深度校验所有已完成的分片，损坏的删除并重置为未完成，返回损坏数量
+func (d *Downloader) verifyHLSSegments(task *engine.VideoTask) int {
+	var bad []int
+	for i := range task.InternalState.HLSSegments {
+		seg := &task.InternalState.HLSSegments[i]
+		if !seg.IsFinished {
+			continue
+		}
+		path := d.segmentPath(task, seg)
+		if err := verifyTSFile(path, seg.Encrypted, true); err != nil {
+			d.manager.Logf(task.ID, engine.LogWarn, "分片 %d 校验失败，已删除: %v", seg.Index, err)
+			_ = os.Remove(path)
+			seg.IsFinished = false
+			bad = append(bad, seg.Index)
+		}
+	}
+	d.manager.MarkUnits(task.ID, engine.UnitHLSSegment, bad, false)
+	return len(bad)
+}
+
+// verifyMP4Chunks 深度校验所有分块的大小与内容，损坏的删除并重置为未完成，返回损坏数量
+func (d *Downloader) verifyMP4Chunks(task *engine.VideoTask) int {
+	var bad []int
+	for i := range task.InternalState.MP4Chunks {
+		chunk := &task.InternalState.MP4Chunks[i]
+		if !chunk.IsFinished {
+			continue
+		}
+		path := d.chunkPath(task, chunk)
+		if err := verifyMP4ChunkFile(path, chunk, task.Size); err != nil {
+			d.manager.Logf(task.ID, engine.LogWarn, "分块 %d 校验失败，已删除: %v", chunk.Index, err)
+			_ = os.Remove(path)
+			chunk.IsFinished = false
+			bad = append(bad, chunk.Index)
+		}
+	}
+	d.manager.MarkUnits(task.ID, engine.UnitMP4Chunk, bad, false)
+	return len(bad)
+}
+
+func verifyMP4ChunkFile(path string, chunk *engine.MP4ChunkState, totalSize int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	expected := chunk.End - chunk.Start + 1
+	if chunk.End == -1 {
+		expected = totalSize
+	}
+	if expected > 0 && info.Size() != expected {
+		return engine.NewTaskError(engine.ErrCodeIntegrity, "分块大小不符: 期望 %d 字节，实际 %d 字节", expected, info.Size())
+	}
+	if chunk.Start == 0 {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		head := make([]byte, 64)
+		n, _ := io.ReadFull(f, head)
+		if looksLikeHTML(head[:n]) {
+			return engine.NewTaskError(engine.ErrCodeHTMLResponse, "分块内容是 HTML 页面")
+		}
+	}
+	return nil
+}
+
+// repairUntilValid 循环执行 深度校验 -> 重下损坏单元，直到全部通过或超过重试轮数
+func (d *Downloader) repairUntilValid(ctx context.Context, task *engine.VideoTask, verify func(*engine.VideoTask) int, redownload func(context.Context, *engine.VideoTask) error) error {
+	for pass := 0; ; pass++ {
+		bad := verify(task)
+		if bad == 0 {
+			return nil
+		}
+		if pass >= maxRepairPasses {
+			return engine.NewTaskError(engine.ErrCodeIntegrity, "深度校验失败: %d 个单元重下后仍然损坏", bad)
+		}
+		d.manager.Logf(task.ID, engine.LogInfo, "深度校验发现 %d 个损坏单元，第 %d 次重下", bad, pass+1)
+		if err := redownload(ctx, task); err != nil {
+			return err
+		}
+	}
+}
+
+// needsDeepVerify 本次下载是否在合并前深度校验：开启了设置，或者是手动校验已完成任务后重下损坏的单元
+func (d *Downloader) needsDeepVerify(task *engine.VideoTask) bool {
+	return d.settings.Get().DeepVerify || task.UnitsRetained
+}
+
+// mergeTarget 合并的输出路径
+// 已完成任务校验后重新合并时覆盖原来的输出文件，否则自动编号避免覆盖同名文件
+func (d *Downloader) mergeTarget(task *engine.VideoTask) string {
+	if task.UnitsRetained && task.SavePath != "" {
+		return task.SavePath
+	}
+	return d.resolveFinalPath(task.SavePath)
+}
+
+// finishMerge 合并成功后，已深度校验的任务清理临时目录；
+// 未校验的保留分片文件，之后可通过 Verify 校验已完成的任务并只重下损坏的单元
+func (d *Downloader) finishMerge(task *engine.VideoTask, verified bool) {
+	if verified {
+		_ = os.RemoveAll(task.TempDir)
+	} else {
+		d.manager.Logf(task.ID, engine.LogInfo, "已保留分片文件，深度校验通过或删除任务后清理: %s", task.TempDir)
+	}
+	task.UnitsRetained = !verified
+	d.manager.UpdateTask(task.ID, func(t *engine.VideoTask) { t.UnitsRetained = !verified })
+}
+
+// Verify 对任务的分片做一次深度校验，返回损坏的单元数量
+// 未完成的任务校验已下载的单元；已完成的任务在合并时保留了分片文件（见 finishMerge），
+// 校验通过后清理分片，发现损坏时重下这些单元并重新合并，覆盖原来的输出文件
+func (d *Downloader) Verify(taskID string) (int, error) {
+	task := d.manager.GetTaskByID(taskID)
+	if task == nil {
+		return 0, fmt.Errorf("任务不存在")
+	}
+	if _, running := d.activeTasks.Load(taskID); running {
+		return 0, fmt.Errorf("任务正在下载，请先暂停")
+	}
+	if task.InternalState == nil {
+		return 0, fmt.Errorf("任务尚未开始下载")
+	}
+	if _, err := os.Stat(task.TempDir); err != nil {
+		// 合并前已深度校验过的任务（或校验通过后）会清理分片文件
+		return 0, fmt.Errorf("分片文件已清理，无法校验")
+	}
+
+	var bad int
+	switch strings.ToLower(task.Type) {
+	case "hls":
+		bad = d.verifyHLSSegments(task)
+	default:
+		bad = d.verifyMP4Chunks(task)
+	}
+	switch {
+	case bad > 0:
+		d.Start(taskID)
+	case task.UnitsRetained:
+		d.manager.Logf(taskID, engine.LogInfo, "深度校验通过，清理分片文件")
+		d.finishMerge(task, true)
+	}
+	return bad, nil
+}
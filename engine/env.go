@@ -2,7 +2,9 @@ package engine
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 )
 
 // EnvResolver 负责定位系统中的外部资源路径
@@ -17,6 +19,11 @@ func NewEnvResolver() *EnvResolver {
 	}
 }
 
+// GetExeDir 程序所在目录（任务文件、设置文件等数据的存放位置）
+func (e *EnvResolver) GetExeDir() string {
+	return e.exeDir
+}
+
 // GetToolPath 获取工具（ffmpeg/chrome）或配置文件（sniff_rules.json）的绝对路径
 // toolName: "ffmpeg", "chrome", "config" 等
 // fileName: "ffmpeg.exe", "chrome.exe", "sniff_rules.json"
@@ -37,14 +44,28 @@ func (e *EnvResolver) GetToolPath(toolName, fileName string) string {
 	return "" // 如果都没找到，返回空，由调用方处理报错
 }
 
-// GetFFmpegPath 快捷获取 FFmpeg
+// exeName 补齐当前系统的可执行文件后缀
+func exeName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// GetFFmpegPath 快捷获取 FFmpeg，程序目录中没有时从 PATH 查找
 func (e *EnvResolver) GetFFmpegPath() string {
-	return e.GetToolPath("ffmpeg", "ffmpeg.exe")
+	if path := e.GetToolPath("ffmpeg", exeName("ffmpeg")); path != "" {
+		return path
+	}
+	if path, err := exec.LookPath("ffmpeg"); err == nil {
+		return path
+	}
+	return ""
 }
 
-// GetChromePath 快捷获取 Chrome
+// GetChromePath 快捷获取程序自带的 Chrome
 func (e *EnvResolver) GetChromePath() string {
-	return e.GetToolPath("chrome", "chrome.exe")
+	return e.GetToolPath("chrome", exeName("chrome"))
 }
 
 // GetRulesPath 快捷获取嗅探规则
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// 任务错误码，前端据此展示提示与建议操作，取值保持稳定
+const (
+	ErrCodeHTTPForbidden    = "http_forbidden"    // 403，通常是链接过期或防盗链，需要重新捕获
+	ErrCodeHTTPNotFound     = "http_not_found"    // 404/410
+	ErrCodeHTTPStatus       = "http_status"       // 其他非 2xx 状态
+	ErrCodeHTMLResponse     = "html_response"     // 服务器返回了 HTML 错误页
+	ErrCodeTruncated        = "truncated"         // 响应体长度与 Content-Length 不符
+	ErrCodeIntegrity        = "integrity"         // 分片/分块内容校验失败
+	ErrCodeRangeUnsupported = "range_unsupported" // 需要续传但服务器不支持 Range
+	ErrCodePlaylist         = "playlist"          // m3u8 解析失败或类型不支持
+	ErrCodeNetwork          = "network"           // 连接失败、被重置等
+	ErrCodeTimeout          = "timeout"
+	ErrCodeFFmpegMissing    = "ffmpeg_missing"
+	ErrCodeFFmpegFailed     = "ffmpeg_failed"
+	ErrCodeDiskFull         = "disk_full"
+	ErrCodeIO               = "io" // 其他本地文件读写错误
+	ErrCodeUnknown          = "unknown"
+)
+
+// maxErrorHistory 每个任务保留的历史错误条数
+const maxErrorHistory = 20
+
+// TaskError 任务失败的结构化信息，同时实现 error 接口供下载流程直接返回
+type TaskError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	UnitIndex  int    `json:"unitIndex"`  // 出错的分块/分片序号，-1 表示与具体单元无关
+	HTTPStatus int    `json:"httpStatus"` // 0 表示非 HTTP 错误
+	Time       int64  `json:"time"`       // Unix 毫秒
+}
+
+func (e *TaskError) Error() string {
+	if e.UnitIndex >= 0 {
+		return fmt.Sprintf("[%s] 单元 %d: %s", e.Code, e.UnitIndex, e.Message)
+	}
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// NewTaskError 构造与单元无关的错误
+func NewTaskError(code, format string, args ...interface{}) *TaskError {
+	return &TaskError{Code: code, Message: fmt.Sprintf(format, args...), UnitIndex: -1}
+}
+
+// NewHTTPError 根据状态码构造错误
+func NewHTTPError(status int, statusText string) *TaskError {
+	code := ErrCodeHTTPStatus
+	switch status {
+	case http.StatusForbidden, http.StatusUnauthorized:
+		code = ErrCodeHTTPForbidden
+	case http.StatusNotFound, http.StatusGone:
+		code = ErrCodeHTTPNotFound
+	}
+	return &TaskError{Code: code, Message: "服务器响应异常: " + statusText, UnitIndex: -1, HTTPStatus: status}
+}
+
+// WithUnit 为错误补充单元序号，已有序号的不覆盖
+func WithUnit(err error, unitIndex int) error {
+	if err == nil {
+		return nil
+	}
+	te := ClassifyError(err)
+	if te.UnitIndex < 0 {
+		te.UnitIndex = unitIndex
+	}
+	return te
+}
+
+// ClassifyError 将任意错误归类为 TaskError（返回副本，不修改原错误）
+func ClassifyError(err error) *TaskError {
+	var te *TaskError
+	if errors.As(err, &te) {
+		c := *te
+		return &c
+	}
+
+	var netErr net.Error
+	var errno syscall.Errno
+	var pathErr *os.PathError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &TaskError{Code: ErrCodeTimeout, Message: err.Error(), UnitIndex: -1}
+	case errors.As(err, &errno) && isDiskFull(errno):
+		return &TaskError{Code: ErrCodeDiskFull, Message: "磁盘空间不足: " + err.Error(), UnitIndex: -1}
+	case errors.As(err, &pathErr):
+		// os.PathError 与 syscall.Errno 也实现了 net.Error，需先于网络错误判断
+		return &TaskError{Code: ErrCodeIO, Message: err.Error(), UnitIndex: -1}
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return &TaskError{Code: ErrCodeTimeout, Message: err.Error(), UnitIndex: -1}
+		}
+		return &TaskError{Code: ErrCodeNetwork, Message: err.Error(), UnitIndex: -1}
+	}
+	return &TaskError{Code: ErrCodeUnknown, Message: err.Error(), UnitIndex: -1}
+}
+
+func isDiskFull(errno syscall.Errno) bool {
+	if errno == syscall.ENOSPC {
+		return true
+	}
+	// Windows: ERROR_HANDLE_DISK_FULL(39) / ERROR_DISK_FULL(112)
+	return runtime.GOOS == "windows" && (errno == 39 || errno == 112)
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// timeoutError 模拟读超时的 net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNewHTTPError(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{401, ErrCodeHTTPForbidden},
+		{403, ErrCodeHTTPForbidden},
+		{404, ErrCodeHTTPNotFound},
+		{410, ErrCodeHTTPNotFound},
+		{416, ErrCodeHTTPStatus},
+		{500, ErrCodeHTTPStatus},
+		{503, ErrCodeHTTPStatus},
+	}
+	for _, tt := range tests {
+		te := NewHTTPError(tt.status, fmt.Sprintf("%d Status", tt.status))
+		if te.Code != tt.want || te.HTTPStatus != tt.status || te.UnitIndex != -1 {
+			t.Errorf("NewHTTPError(%d) = %+v, want code %s", tt.status, te, tt.want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+	}{
+		{"HTTP 403", NewHTTPError(403, "403 Forbidden"), ErrCodeHTTPForbidden, 403},
+		{"包装后的 HTTP 404", fmt.Errorf("下载分片失败: %w", NewHTTPError(404, "404 Not Found")), ErrCodeHTTPNotFound, 404},
+		{"HTTP 5xx", NewHTTPError(502, "502 Bad Gateway"), ErrCodeHTTPStatus, 502},
+		{"context 超时", fmt.Errorf("请求失败: %w", context.DeadlineExceeded), ErrCodeTimeout, 0},
+		{"网络读超时", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, ErrCodeTimeout, 0},
+		{"连接被拒绝", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrCodeNetwork, 0},
+		{"磁盘已满", &os.PathError{Op: "write", Path: "seg.ts", Err: syscall.ENOSPC}, ErrCodeDiskFull, 0},
+		{"其他文件错误", &os.PathError{Op: "open", Path: "seg.ts", Err: syscall.EACCES}, ErrCodeIO, 0},
+		{"未知错误", errors.New("boom"), ErrCodeUnknown, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := ClassifyError(tt.err)
+			if te.Code != tt.wantCode || te.HTTPStatus != tt.wantStatus {
+				t.Errorf("ClassifyError = %+v, want code %s status %d", te, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorReturnsCopy(t *testing.T) {
+	orig := NewTaskError(ErrCodeIntegrity, "校验失败")
+	te := ClassifyError(orig)
+	te.UnitIndex = 5
+	if orig.UnitIndex != -1 {
+		t.Error("ClassifyError modified the original error")
+	}
+}
+
+func TestWithUnit(t *testing.T) {
+	if WithUnit(nil, 3) != nil {
+		t.Error("WithUnit(nil) should be nil")
+	}
+	tests := []struct {
+		name     string
+		err      error
+		want     int
+		wantCode string
+	}{
+		{"补充序号", NewHTTPError(404, "404"), 3, ErrCodeHTTPNotFound},
+		{"已有序号不覆盖", &TaskError{Code: ErrCodeIntegrity, UnitIndex: 7}, 7, ErrCodeIntegrity},
+		{"普通错误先归类", context.DeadlineExceeded, 3, ErrCodeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var te *TaskError
+			if !errors.As(WithUnit(tt.err, 3), &te) {
+				t.Fatal("WithUnit did not return a TaskError")
+			}
+			if te.UnitIndex != tt.want || te.Code != tt.wantCode {
+				t.Errorf("WithUnit = %+v, want unit %d code %s", te, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
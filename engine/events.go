@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// eventFlushInterval 进度与列表变更事件的合并发送周期（4 Hz）
+const eventFlushInterval = 250 * time.Millisecond
+
+// TaskProgress 进度事件中单个任务的精简信息，避免每次传输完整任务（含单元计划）
+type TaskProgress struct {
+	ID               string  `json:"id"`
+	Status           string  `json:"status"`
+	Progress         float64 `json:"progress"`
+	Downloaded       int64   `json:"downloaded"`
+	Size             int64   `json:"size"`
+	Speed            string  `json:"speed"`
+	RemainingSeconds int64   `json:"remainingSeconds"`
+}
+
+// TaskDelta 任务列表的增量变更，前端依次应用 removed、added、updated
+// 事件中的任务不含 InternalState
+type TaskDelta struct {
+	Added   []*VideoTask `json:"added"`
+	Updated []*VideoTask `json:"updated"`
+	Removed []string     `json:"removed"`
+}
+
+// eventBatcher 记录一个周期内发生变化的任务 ID，由 Manager 定时合并发送
+type eventBatcher struct {
+	mu       sync.Mutex
+	progress map[string]bool
+	added    map[string]bool
+	updated  map[string]bool
+	removed  map[string]bool
+	stop     chan struct{}
+}
+
+func newEventBatcher() *eventBatcher {
+	b := &eventBatcher{stop: make(chan struct{})}
+	b.reset()
+	return b
+}
+
+func (b *eventBatcher) reset() {
+	b.progress = make(map[string]bool)
+	b.added = make(map[string]bool)
+	b.updated = make(map[string]bool)
+	b.removed = make(map[string]bool)
+}
+
+func (b *eventBatcher) markProgress(id string) {
+	b.mu.Lock()
+	b.progress[id] = true
+	b.mu.Unlock()
+}
+
+func (b *eventBatcher) markAdded(id string) {
+	b.mu.Lock()
+	b.added[id] = true
+	delete(b.updated, id)
+	b.mu.Unlock()
+}
+
+func (b *eventBatcher) markUpdated(id string) {
+	b.mu.Lock()
+	if !b.added[id] {
+		b.updated[id] = true
+	}
+	b.mu.Unlock()
+}
+
+// markRemoved 同一周期内先新增后删除的任务两边都不再发送
+func (b *eventBatcher) markRemoved(id string) {
+	b.mu.Lock()
+	wasAdded := b.added[id]
+	delete(b.added, id)
+	delete(b.updated, id)
+	delete(b.progress, id)
+	if !wasAdded {
+		b.removed[id] = true
+	}
+	b.mu.Unlock()
+}
+
+// take 取出并清空本周期的变更
+func (b *eventBatcher) take() (progress, added, updated, removed map[string]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	progress, added, updated, removed = b.progress, b.added, b.updated, b.removed
+	b.reset()
+	return
+}
+
+// runEventLoop 按固定周期发送合并后的事件、采样速度并保存统计，直到 Close
+func (m *Manager) runEventLoop() {
+	ticker := time.NewTicker(eventFlushInterval)
+	defer ticker.Stop()
+	sampler := time.NewTicker(statsSampleInterval)
+	defer sampler.Stop()
+	saver := time.NewTicker(statsSaveInterval)
+	defer saver.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.flushEvents()
+		case <-sampler.C:
+			m.sampleSpeeds()
+			m.emitEvent("global_stats", m.GetGlobalStats())
+		case <-saver.C:
+			if err := m.counters.Save(); err != nil {
+				log.Printf("保存统计失败: %v", err)
+			}
+		case <-m.events.stop:
+			return
+		}
+	}
+}
+
+// flushEvents 发送 task_delta（列表增量）与 task_progress_batch（多任务进度）
+func (m *Manager) flushEvents() {
+	progress, added, updated, removed := m.events.take()
+	if len(progress)+len(added)+len(updated)+len(removed) == 0 {
+		return
+	}
+
+	delta := TaskDelta{Added: []*VideoTask{}, Updated: []*VideoTask{}, Removed: []string{}}
+	var batch []TaskProgress
+
+	m.mu.RLock()
+	for id := range removed {
+		delta.Removed = append(delta.Removed, id)
+	}
+	for id := range added {
+		if task, ok := m.tasks[id]; ok {
+			delta.Added = append(delta.Added, eventSnapshot(task))
+		}
+	}
+	for id := range updated {
+		if task, ok := m.tasks[id]; ok {
+			delta.Updated = append(delta.Updated, eventSnapshot(task))
+		}
+	}
+	for id := range progress {
+		if added[id] || updated[id] {
+			continue // 完整快照里已包含进度
+		}
+		if task, ok := m.tasks[id]; ok {
+			batch = append(batch, TaskProgress{
+				ID:               task.ID,
+				Status:           task.Status,
+				Progress:         task.Progress,
+				Downloaded:       task.Downloaded,
+				Size:             task.Size,
+				Speed:            task.Speed,
+				RemainingSeconds: task.RemainingSeconds,
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(delta.Added)+len(delta.Updated)+len(delta.Removed) > 0 {
+		sortTasks(delta.Added, SortByCreated, true)
+		m.emitEvent("task_delta", delta)
+	}
+	if len(batch) > 0 {
+		m.emitEvent("task_progress_batch", batch)
+	}
+}
+
+// eventSnapshot 事件用的任务副本，不含体积较大的单元计划，调用方需持有读锁
+func eventSnapshot(task *VideoTask) *VideoTask {
+	c := *task
+	c.InternalState = nil
+	return c.Clone()
+}
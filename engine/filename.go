@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFilenameTemplate 默认文件名模板：网页标题
+const DefaultFilenameTemplate = "{title}"
+
+// maxFilenameBytes 单段文件名的最大字节数（留出 " (n).mp4" 与临时后缀的余量）
+const maxFilenameBytes = 180
+
+// FilenameVars 文件名模板中可用的占位符取值
+type FilenameVars struct {
+	Title      string // {title} 网页标题
+	Name       string // {name} 服务器建议的文件名或媒体 URL 的文件名（不含扩展名）
+	Host       string // {host} 来源网页域名（缺失时使用媒体域名）
+	OriginPath string // {origin_path} 来源网页路径
+	Date       string // {date} 创建日期 2006-01-02
+	Rule       string // {rule} 命中的嗅探规则名
+	Resolution string // {resolution} 分辨率（如 1080p）
+	ID         string // {id} 任务 ID 前 8 位
+}
+
+var placeholderRe = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+var knownPlaceholders = map[string]bool{
+	"title": true, "name": true, "host": true, "origin_path": true,
+	"date": true, "rule": true, "resolution": true, "id": true,
+}
+
+// ValidateFilenameTemplate 检查模板是否为空或含有未知占位符
+func ValidateFilenameTemplate(tpl string) error {
+	if strings.TrimSpace(tpl) == "" {
+		return fmt.Errorf("文件名模板不能为空")
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(tpl, -1) {
+		if !knownPlaceholders[m[1]] {
+			return fmt.Errorf("文件名模板包含未知占位符: {%s}", m[1])
+		}
+	}
+	return nil
+}
+
+// NewFilenameVars 根据嗅探事件构造模板变量
+func NewFilenameVars(ev SniffEvent, taskID string) FilenameVars {
+	vars := FilenameVars{
+		Title:      ev.Title,
+		Name:       URLBaseName(ev.Url),
+		Date:       time.Now().Format("2006-01-02"),
+		Rule:       ev.RuleName,
+		Resolution: ev.Resolution,
+		ID:         taskID,
+	}
+	if len(vars.ID) > 8 {
+		vars.ID = vars.ID[:8]
+	}
+	if u, err := url.Parse(ev.OriginUrl); err == nil && u.Host != "" {
+		vars.Host = u.Hostname()
+		vars.OriginPath = strings.Trim(u.Path, "/")
+	} else if u, err := url.Parse(ev.Url); err == nil {
+		vars.Host = u.Hostname()
+	}
+	if vars.Resolution == "" {
+		vars.Resolution = GuessResolution(ev.Url)
+	}
+	return vars
+}
+
+// RenderFilename 渲染模板并逐段净化，返回相对路径（可能包含子目录）
+// 渲染结果为空时返回空字符串，由调用方决定兜底名称
+func RenderFilename(tpl string, vars FilenameVars) string {
+	values := map[string]string{
+		"title":       vars.Title,
+		"name":        vars.Name,
+		"host":        vars.Host,
+		"origin_path": vars.OriginPath,
+		"date":        vars.Date,
+		"rule":        vars.Rule,
+		"resolution":  vars.Resolution,
+		"id":          vars.ID,
+	}
+	// 占位符取值内的分隔符不能产生子目录
+	escape := strings.NewReplacer("/", "_", "\\", "_")
+	rendered := placeholderRe.ReplaceAllStringFunc(tpl, func(m string) string {
+		return escape.Replace(values[m[1:len(m)-1]])
+	})
+
+	// 模板本身的 / 或 \ 视为子目录分隔符
+	var parts []string
+	for _, seg := range strings.FieldsFunc(rendered, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if clean := SanitizeFilename(seg); clean != "" {
+			parts = append(parts, clean)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename 将任意字符串净化为可在 Windows/macOS/Linux 上使用的单段文件名
+func SanitizeFilename(name string) string {
+	var sb strings.Builder
+	lastSpace := false
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r):
+			continue
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			r = '_'
+		case unicode.IsSpace(r):
+			if lastSpace {
+				continue
+			}
+			r = ' '
+		}
+		lastSpace = r == ' '
+		sb.WriteRune(r)
+	}
+
+	clean := strings.TrimSpace(sb.String())
+	// Windows 不允许以点或空格结尾，也不应出现 . / .. 这类特殊名称
+	clean = strings.TrimRight(clean, ". ")
+	if clean == "" {
+		return ""
+	}
+
+	stem := clean
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[strings.ToUpper(stem)] {
+		clean = "_" + clean
+	}
+
+	return truncateUTF8(clean, maxFilenameBytes)
+}
+
+// truncateUTF8 按字节截断但不破坏多字节字符
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return strings.TrimRight(s[:cut], ". ")
+}
+
+// URLBaseName 解析 URL 路径中的文件名（不含扩展名）
+// 例子: "https://a.com/v/abc12345.ts?auth=123" -> "abc12345"
+func URLBaseName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	// path.Base 会处理 /path/to/file.mp4 -> file.mp4
+	// 同时由于 u.Path 已经不包含 ?query，所以净化自动完成
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		return ""
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+var resolutionRe = regexp.MustCompile(`(?i)(?:^|[^0-9])(2160|1440|1080|720|540|480|360|240)p(?:[^a-z0-9]|$)`)
+
+// GuessResolution 从 URL 中猜测分辨率标记（如 _1080p.mp4）
+func GuessResolution(rawURL string) string {
+	if m := resolutionRe.FindStringSubmatch(rawURL); m != nil {
+		return m[1] + "p"
+	}
+	return ""
+}
+
+// mediaExts 已知的媒体容器扩展名
+var mediaExts = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".mkv": true,
+	".flv": true, ".ts": true, ".m4a": true, ".mp3": true, ".aac": true,
+}
+
+// contentTypeExts Content-Type 到容器扩展名的映射
+var contentTypeExts = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/x-m4v":      ".m4v",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"audio/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"audio/x-matroska": ".mkv",
+	"video/x-flv":      ".flv",
+	"video/mp2t":       ".ts",
+	"audio/mp4":        ".m4a",
+	"audio/x-m4a":      ".m4a",
+	"audio/m4a":        ".m4a",
+	"audio/mpeg":       ".mp3",
+	"audio/aac":        ".aac",
+	"audio/x-aac":      ".aac",
+}
+
+// IsMediaExt 判断扩展名（含点，大小写不敏感）是否为已知媒体容器
+func IsMediaExt(ext string) bool {
+	return mediaExts[strings.ToLower(ext)]
+}
+
+// ExtFromContentType 根据 Content-Type 推断容器扩展名，未知返回空
+// application/octet-stream 等通用类型不作判断
+func ExtFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return contentTypeExts[strings.ToLower(mediaType)]
+}
+
+// FilenameFromContentDisposition 解析 Content-Disposition 中的文件名
+// mime.ParseMediaType 会处理 RFC 5987 的 filename*=UTF-8”... 编码并优先于 filename
+func FilenameFromContentDisposition(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	// 服务器可能带上路径，只取最后一段
+	name := params["filename"]
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSpace(name)
+}
@@ -1,10 +1,13 @@
 package engine
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/grafov/m3u8"
 )
@@ -59,3 +62,90 @@ func (p *HLSParser) resolveURL(base, ref string) string {
 	}
 	return baseU.ResolveReference(u).String()
 }
+
+// HLSVariant Master Playlist 中的一个码率
+type HLSVariant struct {
+	URL        string  `json:"url"`
+	Bandwidth  int64   `json:"bandwidth"`
+	Resolution string  `json:"resolution"` // 如 1920x1080
+	Codecs     string  `json:"codecs"`
+	FrameRate  float64 `json:"frameRate"`
+}
+
+// PlaylistInfo 嗅探时从 m3u8 内容中预解析的信息
+type PlaylistInfo struct {
+	IsMaster     bool
+	Variants     []HLSVariant // 按码率从高到低，不含仅 I 帧的变体
+	Duration     float64      // 媒体列表的总时长（秒）
+	SegmentCount int
+	SegmentURLs  []string // 分片的绝对地址
+	Encryption   string   // EXT-X-KEY 的 METHOD，未加密为空
+	IsLive       bool     // 媒体列表没有 EXT-X-ENDLIST
+}
+
+// ParsePlaylistInfo 解析 m3u8 内容，相对地址基于 playlistURL 补全
+// 使用非严格模式，尽量容忍站点不规范的播放列表
+func ParsePlaylistInfo(body []byte, playlistURL string) (*PlaylistInfo, error) {
+	playlist, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), false)
+	if err != nil {
+		return nil, fmt.Errorf("解码 m3u8 失败: %v", err)
+	}
+	p := &HLSParser{}
+	info := &PlaylistInfo{}
+
+	switch listType {
+	case m3u8.MASTER:
+		info.IsMaster = true
+		for _, v := range playlist.(*m3u8.MasterPlaylist).Variants {
+			if v == nil || v.Iframe || v.URI == "" {
+				continue
+			}
+			info.Variants = append(info.Variants, HLSVariant{
+				URL:        p.resolveURL(playlistURL, v.URI),
+				Bandwidth:  int64(v.Bandwidth),
+				Resolution: v.Resolution,
+				Codecs:     v.Codecs,
+				FrameRate:  v.FrameRate,
+			})
+		}
+		sort.SliceStable(info.Variants, func(i, j int) bool {
+			return info.Variants[i].Bandwidth > info.Variants[j].Bandwidth
+		})
+
+	case m3u8.MEDIA:
+		media := playlist.(*m3u8.MediaPlaylist)
+		info.IsLive = !media.Closed
+		key := media.Key
+		for _, seg := range media.Segments {
+			if seg == nil {
+				continue
+			}
+			if seg.Key != nil {
+				key = seg.Key
+			}
+			if key != nil && key.Method != "" && !strings.EqualFold(key.Method, "NONE") && info.Encryption == "" {
+				info.Encryption = key.Method
+			}
+			info.Duration += seg.Duration
+			info.SegmentCount++
+			info.SegmentURLs = append(info.SegmentURLs, p.resolveURL(playlistURL, seg.URI))
+		}
+		if media.Map != nil && media.Map.URI != "" {
+			info.SegmentURLs = append(info.SegmentURLs, p.resolveURL(playlistURL, media.Map.URI))
+		}
+	}
+	return info, nil
+}
+
+// ResolutionLabel 将 1920x1080 形式的分辨率转为 1080p，无法解析返回空
+func ResolutionLabel(resolution string) string {
+	_, h, ok := strings.Cut(strings.ToLower(resolution), "x")
+	if !ok {
+		return ""
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(h))
+	if err != nil || height <= 0 {
+		return ""
+	}
+	return strconv.Itoa(height) + "p"
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+const testMasterPlaylist = `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360
+360p/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="iframe.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2",FRAME-RATE=30.000
+https://other.example.com/1080p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720
+/abs/720p.m3u8
+`
+
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXTINF:10.0,
+seg0.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:9.5,
+seg1.ts
+#EXTINF:4.5,
+https://seg.example.net/seg2.ts
+#EXT-X-ENDLIST
+`
+
+const testLivePlaylist = `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:100
+#EXTINF:6.0,
+100.ts
+#EXTINF:6.0,
+101.ts
+`
+
+func TestParsePlaylistInfoMaster(t *testing.T) {
+	info, err := ParsePlaylistInfo([]byte(testMasterPlaylist), "https://cdn.example.com/hls/42/master.m3u8?token=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsMaster {
+		t.Fatal("not detected as master playlist")
+	}
+	var urls []string
+	for _, v := range info.Variants {
+		urls = append(urls, v.URL)
+	}
+	// 按码率从高到低，不含 I 帧变体，相对地址已补全
+	want := []string{
+		"https://other.example.com/1080p.m3u8",
+		"https://cdn.example.com/abs/720p.m3u8",
+		"https://cdn.example.com/hls/42/360p/index.m3u8",
+	}
+	if !slices.Equal(urls, want) {
+		t.Errorf("variants = %v, want %v", urls, want)
+	}
+	top := info.Variants[0]
+	if top.Bandwidth != 5000000 || top.Resolution != "1920x1080" || top.Codecs != "avc1.640028,mp4a.40.2" || top.FrameRate != 30 {
+		t.Errorf("variant attributes = %+v", top)
+	}
+	if info.Duration != 0 || info.SegmentCount != 0 {
+		t.Errorf("master playlist has duration %v / %d segments", info.Duration, info.SegmentCount)
+	}
+}
+
+func TestParsePlaylistInfoMedia(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantDuration float64
+		wantSegments int
+		wantEnc      string
+		wantLive     bool
+		wantFirstURL string
+	}{
+		{"点播加密", testMediaPlaylist, 24, 3, "AES-128", false, "https://cdn.example.com/hls/42/seg0.ts"},
+		{"直播", testLivePlaylist, 12, 2, "", true, "https://cdn.example.com/hls/42/100.ts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParsePlaylistInfo([]byte(tt.body), "https://cdn.example.com/hls/42/index.m3u8")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.IsMaster {
+				t.Fatal("media playlist detected as master")
+			}
+			if info.Duration != tt.wantDuration || info.SegmentCount != tt.wantSegments {
+				t.Errorf("duration/segments = %v/%d, want %v/%d", info.Duration, info.SegmentCount, tt.wantDuration, tt.wantSegments)
+			}
+			if info.Encryption != tt.wantEnc || info.IsLive != tt.wantLive {
+				t.Errorf("encryption/live = %q/%v, want %q/%v", info.Encryption, info.IsLive, tt.wantEnc, tt.wantLive)
+			}
+			if len(info.SegmentURLs) != tt.wantSegments || info.SegmentURLs[0] != tt.wantFirstURL {
+				t.Errorf("segment URLs = %v", info.SegmentURLs)
+			}
+		})
+	}
+}
+
+func TestParsePlaylistInfoInvalid(t *testing.T) {
+	if _, err := ParsePlaylistInfo([]byte("<html></html>"), "https://cdn.example.com/a.m3u8"); err == nil {
+		t.Error("expected error for non-m3u8 content")
+	}
+}
+
+func TestResolutionLabel(t *testing.T) {
+	tests := map[string]string{
+		"1920x1080": "1080p",
+		"1280X720":  "720p",
+		"640x 360":  "360p",
+		"1080":      "",
+		"1920x":     "",
+		"1920x-1":   "",
+		"":          "",
+	}
+	for in, want := range tests {
+		if got := ResolutionLabel(in); got != want {
+			t.Errorf("ResolutionLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -2,10 +2,8 @@ package engine
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"log"
 	"sync"
 	"time"
 
@@ -17,40 +15,115 @@ type taskSnap struct {
 	lastBytes int64
 	lastTime  time.Time
 	currBps   float64 // 平滑后的每秒字节数
+	seenBytes int64   // 上一次上报的已下载量，用于累计流量
+	history   speedRing
 }
 
 type Manager struct {
-	ctx         context.Context
-	tasks       map[string]*VideoTask
-	stats       map[string]*taskSnap // 任务 ID -> 统计快照
-	mu          sync.RWMutex
-	storagePath string
+	ctx       context.Context
+	tasks     map[string]*VideoTask
+	stats     map[string]*taskSnap // 任务 ID -> 统计快照
+	mu        sync.RWMutex
+	store     TaskStore
+	loadIssue string       // 启动加载时的异常（损坏、已从备份恢复等），供前端提示
+	logs      *TaskLogBook // 每个任务的活动日志（仅内存）
+	events    *eventBatcher
+	counters  *StatsStore // 今日流量、按站点累计等持久化统计
+	// globalSpeed 全局速度采样，由 mu 保护
+	globalSpeed speedRing
+	loopOnce    sync.Once
+	closeOnce   sync.Once
 }
 
-func NewManager() *Manager {
-	exePath, _ := os.Executable()
-	storagePath := filepath.Join(filepath.Dir(exePath), "tasks.json")
-
+func NewManager(store TaskStore, counters *StatsStore) *Manager {
 	m := &Manager{
-		tasks:       make(map[string]*VideoTask),
-		stats:       make(map[string]*taskSnap),
-		storagePath: storagePath,
+		tasks:    make(map[string]*VideoTask),
+		stats:    make(map[string]*taskSnap),
+		store:    store,
+		logs:     NewTaskLogBook(),
+		events:   newEventBatcher(),
+		counters: counters,
 	}
 
-	m.loadFromDisk()
+	m.loadFromStore()
 	return m
 }
 
 func (m *Manager) SetContext(ctx context.Context) {
 	m.ctx = ctx
+	if m.loadIssue != "" {
+		m.emitEvent("storage_error", m.loadIssue)
+	}
+	m.loopOnce.Do(func() { go m.runEventLoop() })
 }
 
+// GetLoadIssue 返回启动加载任务文件时遇到的问题，无问题返回空字符串
+func (m *Manager) GetLoadIssue() string {
+	return m.loadIssue
+}
+
+// AddTask 新增或整体替换任务，Manager 保存的是副本，调用方之后对 task 的修改不会生效
 func (m *Manager) AddTask(task *VideoTask) {
+	c := task.Clone()
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().UnixMilli()
+	}
+	m.mu.Lock()
+	m.tasks[c.ID] = c
+	m.mu.Unlock()
+	m.Logf(c.ID, LogInfo, "创建任务: type=%s url=%s", c.Type, c.Url)
+	m.persist(m.store.PutTask(c.Clone()))
+	m.events.markAdded(c.ID)
+}
+
+// UpdateTask 在锁内修改任务字段并持久化，任务不存在返回 false
+// fn 中不要调用 Manager 的其他方法，也不要保留 t 的引用
+func (m *Manager) UpdateTask(id string, fn func(t *VideoTask)) bool {
 	m.mu.Lock()
-	m.tasks[task.ID] = task
+	task, ok := m.tasks[id]
+	var snapshot *VideoTask
+	if ok {
+		fn(task)
+		snapshot = task.Clone()
+	}
 	m.mu.Unlock()
-	m.saveToDisk()
-	m.emitEvent("task_list_updated", m.GetAllTasks())
+	if !ok {
+		return false
+	}
+	m.persist(m.store.PutTask(snapshot))
+	m.events.markUpdated(id)
+	return true
+}
+
+// SetInternalState 替换任务的单元计划（分块/分片列表）并持久化，保存的是副本
+func (m *Manager) SetInternalState(id string, state *TaskInternalState) {
+	m.mu.Lock()
+	task, ok := m.tasks[id]
+	if ok {
+		task.InternalState = state.Clone()
+	}
+	m.mu.Unlock()
+	if ok {
+		m.persist(m.store.PutUnits(id, state.Clone()))
+	}
+}
+
+// MarkUnits 更新若干单元的完成状态，只向存储追加增量，不通知前端
+func (m *Manager) MarkUnits(id string, kind UnitKind, indexes []int, finished bool) {
+	if len(indexes) == 0 {
+		return
+	}
+	m.mu.Lock()
+	task, ok := m.tasks[id]
+	if ok && task.InternalState != nil {
+		for _, idx := range indexes {
+			task.InternalState.SetUnitFinished(kind, idx, finished)
+		}
+	}
+	m.mu.Unlock()
+	if ok {
+		m.persist(m.store.MarkUnits(id, kind, indexes, finished))
+	}
 }
 
 // UpdateTaskProgress 后端核心：计算速度和 ETA
@@ -71,8 +144,15 @@ func (m *Manager) UpdateTaskProgress(id string, downloaded int64, _ string) {
 		m.stats[id] = &taskSnap{
 			lastBytes: downloaded,
 			lastTime:  now,
+			seenBytes: downloaded,
 		}
 	} else {
+		// 只累计增长部分，校验删除坏分片导致的回退不计入
+		if diff := downloaded - snap.seenBytes; diff > 0 {
+			m.counters.AddBytes(taskHost(task), diff)
+		}
+		snap.seenBytes = downloaded
+
 		// 计算增量
 		duration := now.Sub(snap.lastTime).Seconds()
 		if duration >= 0.5 { // 每 0.5 秒计算一次，避免过于频繁导致读数不稳定
@@ -93,7 +173,7 @@ func (m *Manager) UpdateTaskProgress(id string, downloaded int64, _ string) {
 			// 更新任务状态
 			task.Speed = m.formatSpeed(snap.currBps)
 			if task.Size > 0 && snap.currBps > 0 {
-				task.RemainingSeconds = int64(float64(task.Size-downloaded) / snap.currBps)
+				task.RemainingSeconds = max(int64(float64(task.Size-downloaded)/snap.currBps), 0)
 			} else {
 				task.RemainingSeconds = -1 // 未知
 			}
@@ -103,9 +183,24 @@ func (m *Manager) UpdateTaskProgress(id string, downloaded int64, _ string) {
 	task.Downloaded = downloaded
 	if task.Size > 0 {
 		task.Progress = float64(downloaded) / float64(task.Size) * 100
+		// 估算值可能偏小，完成前不显示 100%
+		if task.SizeEstimated && task.Progress > 99.9 {
+			task.Progress = 99.9
+		}
 	}
 
-	m.emitEvent("task_progress", task)
+	m.events.markProgress(id)
+}
+
+// SetEstimatedSize 设置估算的总大小（大小未知的 HLS 任务），进度与剩余时间随之按字节计算
+func (m *Manager) SetEstimatedSize(id string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if task, ok := m.tasks[id]; ok {
+		task.Size = size
+		task.SizeEstimated = true
+		m.events.markProgress(id)
+	}
 }
 
 func (m *Manager) formatSpeed(bps float64) string {
@@ -122,56 +217,138 @@ func (m *Manager) RemoveTask(id string) {
 	delete(m.tasks, id)
 	delete(m.stats, id)
 	m.mu.Unlock()
-	m.saveToDisk()
-	m.emitEvent("task_list_updated", m.GetAllTasks())
+	m.logs.Delete(id)
+	m.persist(m.store.DeleteTask(id))
+	m.events.markRemoved(id)
 }
 
+// GetAllTasks 返回所有任务的深拷贝快照，按创建时间倒序（新任务在前）
 func (m *Manager) GetAllTasks() []*VideoTask {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	list := make([]*VideoTask, 0)
+	list := make([]*VideoTask, 0, len(m.tasks))
 	for _, task := range m.tasks {
-		list = append(list, task)
+		list = append(list, task.Clone())
 	}
+	sortTasks(list, SortByCreated, true)
 	return list
 }
 
 func (m *Manager) UpdateTaskStatus(id string, status string) {
 	m.mu.Lock()
-	if task, ok := m.tasks[id]; ok {
+	task, ok := m.tasks[id]
+	var snapshot *VideoTask
+	var prev string
+	if ok {
+		prev = task.Status
 		task.Status = status
+		now := time.Now().UnixMilli()
+		switch status {
+		case "downloading":
+			task.StartedAt = now
+			task.FinishedAt = 0
+			task.Error = nil // 重新开始后清除当前错误，历史保留
+		case "done":
+			task.FinishedAt = now
+			if prev != "done" {
+				m.counters.AddResult(taskHost(task), true)
+			}
+		}
 		// 如果状态变更为非下载中，清除速度
 		if status != "downloading" {
 			task.Speed = ""
 			task.RemainingSeconds = 0
 			delete(m.stats, id)
 		}
+		snapshot = task.Clone()
 	}
 	m.mu.Unlock()
-	m.saveToDisk()
-	m.emitEvent("task_list_updated", m.GetAllTasks())
+	if ok {
+		m.Logf(id, LogInfo, "状态变更: %s -> %s", prev, status)
+		m.persist(m.store.PutTask(snapshot))
+		m.events.markUpdated(id)
+	}
 }
 
-func (m *Manager) saveToDisk() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	data, _ := json.MarshalIndent(m.tasks, "", "  ")
-	_ = os.WriteFile(m.storagePath, data, 0644)
+// FailTask 将任务标记为失败并记录错误（同时追加到历史），通知前端
+func (m *Manager) FailTask(id string, err error) {
+	te := ClassifyError(err)
+	te.Time = time.Now().UnixMilli()
+
+	m.mu.Lock()
+	task, ok := m.tasks[id]
+	var snapshot *VideoTask
+	if ok {
+		task.Status = "error"
+		task.Speed = ""
+		task.RemainingSeconds = 0
+		delete(m.stats, id)
+
+		m.counters.AddResult(taskHost(task), false)
+		task.Error = te
+		task.ErrorHistory = append(task.ErrorHistory, *te)
+		if n := len(task.ErrorHistory); n > maxErrorHistory {
+			task.ErrorHistory = append([]TaskError(nil), task.ErrorHistory[n-maxErrorHistory:]...)
+		}
+		snapshot = task.Clone()
+	}
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	log.Printf("[Task %s] 失败: %v", id, te)
+	m.Logf(id, LogError, "任务失败: %v", te)
+	m.persist(m.store.PutTask(snapshot))
+	m.emitEvent("task_error", map[string]interface{}{"taskId": id, "error": te})
+	m.events.markUpdated(id)
 }
 
-func (m *Manager) loadFromDisk() {
+// Close 关闭存储后端（程序退出时调用）
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() { close(m.events.stop) })
+	m.flushEvents()
+	if err := m.counters.Save(); err != nil {
+		log.Printf("保存统计失败: %v", err)
+	}
+	m.persist(m.store.Close())
+}
+
+// persist 统一处理存储错误：记录日志并通知前端
+func (m *Manager) persist(err error) {
+	if err != nil {
+		m.reportSaveError(err)
+	}
+}
+
+func (m *Manager) reportSaveError(err error) {
+	msg := fmt.Sprintf("保存任务列表失败: %v", err)
+	log.Print(msg)
+	m.emitEvent("storage_error", msg)
+}
+
+func (m *Manager) loadFromStore() {
+	tasks, issue, err := m.store.Load()
+	if err != nil {
+		issue = joinIssue(issue, fmt.Sprintf("写回任务文件失败: %v", err))
+	}
+
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	data, err := os.ReadFile(m.storagePath)
-	if err == nil {
-		_ = json.Unmarshal(data, &m.tasks)
+	m.tasks = tasks
+	m.loadIssue = issue
+	m.mu.Unlock()
+
+	if issue != "" {
+		log.Print(issue)
 	}
 }
 
+// GetTaskByID 返回任务的深拷贝快照，不存在返回 nil
+// 修改快照不会影响 Manager，需要修改请使用 UpdateTask 等方法
 func (m *Manager) GetTaskByID(id string) *VideoTask {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.tasks[id]
+	return m.tasks[id].Clone()
 }
 
 func (m *Manager) emitEvent(eventName string, data interface{}) {
@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	m := NewManager(NewJSONTaskStore(filepath.Join(dir, "tasks.json")), NewStatsStore(filepath.Join(dir, "stats.json")))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// 快照与 Manager 内部状态互不影响
+func TestManagerSnapshotsAreIsolated(t *testing.T) {
+	m := newTestManager(t)
+	task := &VideoTask{ID: "a", Url: "https://cdn.example.com/v.mp4", Headers: map[string]string{"Referer": "x"}}
+	m.AddTask(task)
+	m.SetInternalState("a", &TaskInternalState{MP4Chunks: []MP4ChunkState{{Index: 0}}})
+
+	// AddTask 保存的是副本
+	task.Headers["Referer"] = "changed"
+
+	snap := m.GetTaskByID("a")
+	snap.Headers["Referer"] = "mutated"
+	snap.InternalState.MP4Chunks[0].IsFinished = true
+	snap.Status = "done"
+
+	for _, got := range []*VideoTask{m.GetTaskByID("a"), m.GetAllTasks()[0]} {
+		if got.Headers["Referer"] != "x" {
+			t.Errorf("headers leaked into manager: %v", got.Headers)
+		}
+		if got.InternalState.MP4Chunks[0].IsFinished {
+			t.Error("unit state leaked into manager")
+		}
+		if got.Status == "done" {
+			t.Error("status leaked into manager")
+		}
+	}
+}
+
+func TestManagerFailTaskErrorHistory(t *testing.T) {
+	m := newTestManager(t)
+	m.AddTask(&VideoTask{ID: "a", Url: "https://cdn.example.com/v.mp4"})
+
+	tests := []struct {
+		failures    int
+		wantHistory int
+	}{
+		{1, 1},
+		{maxErrorHistory - 1, maxErrorHistory},
+		{5, maxErrorHistory}, // 超过上限后只保留最近的记录
+	}
+	n := 0
+	for _, tt := range tests {
+		for i := 0; i < tt.failures; i++ {
+			n++
+			m.FailTask("a", WithUnit(fmt.Errorf("failure %d", n), n))
+		}
+		task := m.GetTaskByID("a")
+		if len(task.ErrorHistory) != tt.wantHistory {
+			t.Fatalf("after %d failures: history = %d, want %d", n, len(task.ErrorHistory), tt.wantHistory)
+		}
+		last := task.ErrorHistory[len(task.ErrorHistory)-1]
+		if task.Status != "error" || task.Error == nil || task.Error.UnitIndex != n || last.UnitIndex != n {
+			t.Errorf("after %d failures: error = %+v, last = %+v", n, task.Error, last)
+		}
+		if first := task.ErrorHistory[0].UnitIndex; first != n-tt.wantHistory+1 {
+			t.Errorf("after %d failures: oldest kept = %d, want %d", n, first, n-tt.wantHistory+1)
+		}
+	}
+
+	// 不存在的任务忽略
+	m.FailTask("missing", errors.New("x"))
+}
+
+// 模拟下载协程、前端查询与事件循环并发访问，需配合 go test -race 运行
+func TestManagerConcurrentAccess(t *testing.T) {
+	m := newTestManager(t)
+	const tasks, units = 4, 16
+	for i := 0; i < tasks; i++ {
+		id := fmt.Sprintf("t%d", i)
+		m.AddTask(&VideoTask{ID: id, Url: "https://cdn.example.com/" + id, Type: "hls", Size: units * 1024})
+		state := &TaskInternalState{}
+		for j := 0; j < units; j++ {
+			state.HLSSegments = append(state.HLSSegments, HLSSegmentState{Index: j})
+		}
+		m.SetInternalState(id, state)
+		m.UpdateTaskStatus(id, "downloading")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < tasks; i++ {
+		id := fmt.Sprintf("t%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < units; j++ {
+				m.UpdateTaskProgress(id, int64(j+1)*1024, "")
+				m.MarkUnits(id, UnitHLSSegment, []int{j}, true)
+				m.UpdateTask(id, func(t *VideoTask) { t.Duration += 1 })
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < units; j++ {
+				for _, task := range m.GetAllTasks() {
+					task.Progress = -1 // 修改快照不应产生数据竞争
+					if task.InternalState != nil {
+						task.InternalState.UnitProgress()
+					}
+				}
+				m.QueryTasks(TaskQuery{})
+				m.GetGlobalStats()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < units; j++ {
+			m.flushEvents()
+			m.sampleSpeeds()
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < tasks; i++ {
+		task := m.GetTaskByID(fmt.Sprintf("t%d", i))
+		if finished, total := task.InternalState.UnitProgress(); finished != units || total != units {
+			t.Errorf("%s: units %d/%d, want %d/%d", task.ID, finished, total, units, units)
+		}
+		if task.Downloaded != units*1024 || task.Duration != units {
+			t.Errorf("%s: downloaded=%d duration=%v", task.ID, task.Downloaded, task.Duration)
+		}
+	}
+}
+
+func TestEventBatcher(t *testing.T) {
+	tests := []struct {
+		name                              string
+		ops                               func(b *eventBatcher)
+		progress, added, updated, removed []string
+	}{
+		{
+			name:  "新增后更新只发送新增",
+			ops:   func(b *eventBatcher) { b.markAdded("a"); b.markUpdated("a") },
+			added: []string{"a"},
+		},
+		{
+			name: "同一周期内新增又删除两边都不发送",
+			ops:  func(b *eventBatcher) { b.markAdded("a"); b.markProgress("a"); b.markRemoved("a") },
+		},
+		{
+			name:    "已有任务删除",
+			ops:     func(b *eventBatcher) { b.markUpdated("a"); b.markProgress("a"); b.markRemoved("a") },
+			removed: []string{"a"},
+		},
+		{
+			name:     "进度与更新分别记录",
+			ops:      func(b *eventBatcher) { b.markProgress("a"); b.markUpdated("b") },
+			progress: []string{"a"},
+			updated:  []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newEventBatcher()
+			tt.ops(b)
+			progress, added, updated, removed := b.take()
+			check := func(kind string, got map[string]bool, want []string) {
+				if len(got) != len(want) {
+					t.Errorf("%s = %v, want %v", kind, got, want)
+					return
+				}
+				for _, id := range want {
+					if !got[id] {
+						t.Errorf("%s = %v, want %v", kind, got, want)
+					}
+				}
+			}
+			check("progress", progress, tt.progress)
+			check("added", added, tt.added)
+			check("updated", updated, tt.updated)
+			check("removed", removed, tt.removed)
+
+			// take 之后清空
+			if p, a, u, r := b.take(); len(p)+len(a)+len(u)+len(r) != 0 {
+				t.Error("batcher not reset after take")
+			}
+		})
+	}
+}
@@ -12,11 +12,15 @@ type VideoTask struct {
 	ID               string            `json:"id"`
 	Title            string            `json:"title"`            // 文件展示标题
 	Url              string            `json:"url"`              // 当前有效的下载链接
+	FinalUrl         string            `json:"finalUrl"`         // 预检时跟随重定向后的最终地址
 	OriginUrl        string            `json:"originUrl"`        // 原始网页地址
 	TargetID         string            `json:"targetId"`         // 来源标签页 ID
 	Type             string            `json:"type"`             // "mp4" 或 "hls"
 	Status           string            `json:"status"`           // "sniffed", "downloading", "paused", "merging", "done", "error"
 	Size             int64             `json:"size"`             // 总大小
+	SizeEstimated    bool              `json:"sizeEstimated"`    // Size 为估算值（HLS 下载完成前）
+	Duration         float64           `json:"duration"`         // 媒体总时长（秒），HLS 由 EXTINF 累加
+	Bandwidth        int64             `json:"bandwidth"`        // 声明码率 bit/s（HLS 的 BANDWIDTH），0 表示未知
 	Downloaded       int64             `json:"downloaded"`       // 已下载大小
 	Progress         float64           `json:"progress"`         // 百分比
 	Speed            string            `json:"speed"`            // 格式化后的速度 (如 "1.2 MB/s")
@@ -24,17 +28,115 @@ type VideoTask struct {
 	SupportRange     bool              `json:"supportRange"`
 	SavePath         string            `json:"savePath"`
 	TempDir          string            `json:"tempDir"`
+	UnitsRetained    bool              `json:"unitsRetained"` // 已合并但尚未深度校验，分片文件保留在 TempDir 中，校验通过或删除任务时清理
 	Headers          map[string]string `json:"headers"`
+	RuleCookie       bool              `json:"ruleCookie"` // Cookie 由规则的 inject_headers 固定指定，下载前不用浏览器 Cookie 覆盖
 	Clips            []TimeRange       `json:"clips"`
+	Tags             []string          `json:"tags"`
+	Error            *TaskError        `json:"error,omitempty"` // 最近一次失败的原因，重新开始后清除
+	ErrorHistory     []TaskError       `json:"errorHistory"`    // 历史失败记录（最多 20 条）
+
+	// 生命周期时间戳（Unix 毫秒），0 表示尚未发生
+	CreatedAt  int64 `json:"createdAt"`
+	StartedAt  int64 `json:"startedAt"`  // 最近一次开始下载的时间
+	FinishedAt int64 `json:"finishedAt"` // 下载完成的时间
 
 	InternalState *TaskInternalState `json:"internalState"`
 }
 
+// Clone 深拷贝任务（含 Headers、Clips、InternalState）
+func (t *VideoTask) Clone() *VideoTask {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	if t.Headers != nil {
+		c.Headers = make(map[string]string, len(t.Headers))
+		for k, v := range t.Headers {
+			c.Headers[k] = v
+		}
+	}
+	if t.Clips != nil {
+		c.Clips = append([]TimeRange(nil), t.Clips...)
+	}
+	if t.Tags != nil {
+		c.Tags = append([]string(nil), t.Tags...)
+	}
+	if t.Error != nil {
+		e := *t.Error
+		c.Error = &e
+	}
+	if t.ErrorHistory != nil {
+		c.ErrorHistory = append([]TaskError(nil), t.ErrorHistory...)
+	}
+	c.InternalState = t.InternalState.Clone()
+	return &c
+}
+
+// UnitKind 下载单元类型，用于增量记录单元进度
+type UnitKind string
+
+const (
+	UnitMP4Chunk   UnitKind = "mp4"
+	UnitHLSSegment UnitKind = "hls"
+)
+
 type TaskInternalState struct {
 	MP4Chunks   []MP4ChunkState   `json:"mp4Chunks,omitempty"`
 	HLSSegments []HLSSegmentState `json:"hlsSegments,omitempty"`
 }
 
+// SetUnitFinished 按单元 Index 设置完成状态，返回是否找到该单元
+func (st *TaskInternalState) SetUnitFinished(kind UnitKind, index int, finished bool) bool {
+	switch kind {
+	case UnitMP4Chunk:
+		for i := range st.MP4Chunks {
+			if st.MP4Chunks[i].Index == index {
+				st.MP4Chunks[i].IsFinished = finished
+				return true
+			}
+		}
+	case UnitHLSSegment:
+		for i := range st.HLSSegments {
+			if st.HLSSegments[i].Index == index {
+				st.HLSSegments[i].IsFinished = finished
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Clone 深拷贝内部状态
+func (st *TaskInternalState) Clone() *TaskInternalState {
+	if st == nil {
+		return nil
+	}
+	c := &TaskInternalState{}
+	if st.MP4Chunks != nil {
+		c.MP4Chunks = append([]MP4ChunkState(nil), st.MP4Chunks...)
+	}
+	if st.HLSSegments != nil {
+		c.HLSSegments = append([]HLSSegmentState(nil), st.HLSSegments...)
+	}
+	return c
+}
+
+// UnitProgress 返回已完成单元数与总单元数
+func (st *TaskInternalState) UnitProgress() (finished, total int) {
+	for _, c := range st.MP4Chunks {
+		if c.IsFinished {
+			finished++
+		}
+	}
+	for _, seg := range st.HLSSegments {
+		if seg.IsFinished {
+			finished++
+		}
+	}
+	return finished, len(st.MP4Chunks) + len(st.HLSSegments)
+}
+
 type MP4ChunkState struct {
 	Index      int   `json:"index"`
 	Start      int64 `json:"start"`
@@ -43,9 +145,11 @@ type MP4ChunkState struct {
 }
 
 type HLSSegmentState struct {
-	Index      int    `json:"index"`
-	URL        string `json:"url"`
-	IsFinished bool   `json:"isFinished"`
+	Index      int     `json:"index"`
+	URL        string  `json:"url"`
+	IsFinished bool    `json:"isFinished"`
+	Encrypted  bool    `json:"encrypted,omitempty"` // 分片经过 EXT-X-KEY 加密，无法按 TS 同步字节校验
+	Duration   float64 `json:"duration,omitempty"`  // EXTINF 时长（秒）
 }
 
 // SniffEvent 嗅探事件数据
@@ -58,4 +162,17 @@ type SniffEvent struct {
 	Size         int64             `json:"size"`
 	SupportRange bool              `json:"supportRange"`
 	Headers      map[string]string `json:"headers"`
+	RuleName     string            `json:"ruleName"`    // 命中的嗅探规则名，通用嗅探为空
+	Resolution   string            `json:"resolution"`  // 分辨率（如 1080p），未知为空
+	ContentType  string            `json:"contentType"` // 响应的 MIME 类型
+	StatusCode   int               `json:"statusCode"`  // 响应状态码
+	SourceUrl    string            `json:"sourceUrl"`   // 规则改写前实际请求的地址，未改写为空
+	MediaID      string            `json:"mediaId"`     // 逻辑媒体 ID，同一视频的重复请求、分段与 HLS 子列表共用
+
+	// 以下为嗅探时预解析 m3u8 得到的信息，非 HLS 为零值
+	Variants     []HLSVariant `json:"variants"`     // 主列表中的码率，按码率从高到低
+	Duration     float64      `json:"duration"`     // 总时长（秒），来自媒体列表
+	SegmentCount int          `json:"segmentCount"` // 分片数
+	Encryption   string       `json:"encryption"`   // 加密方式，如 AES-128，未加密为空
+	IsLive       bool         `json:"isLive"`       // 直播（没有 EXT-X-ENDLIST）
 }
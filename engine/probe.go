@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeSniffBytes 探测时读取的头部字节数，用于识别真实格式
+// 只需 Content-Range 的话 bytes=0-0 就够了，但签名识别（TS 需要第 188 字节）要多读一点
+const probeSniffBytes = 1024
+
+// ProbeResult 资源预检结果
+type ProbeResult struct {
+	Size         int64  // 总大小，未知为 0
+	SupportRange bool   // 是否支持 Range 请求
+	FileName     string // Content-Disposition 中的文件名（含扩展名）
+	ContentType  string
+	FinalURL     string // 跟随重定向后的最终地址
+	DetectedType string // 根据文件头识别的任务类型："hls" / "mp4"，无法识别为空
+	DetectedExt  string // 根据文件头识别的容器扩展名，如 ".ts"
+}
+
+// ProbeResource 探测资源信息
+// 先发 HEAD；再用 GET Range 读取文件头，HEAD 被拒或缺少 Content-Length 时由 Content-Range 补齐总大小
+func ProbeResource(rawURL string, headers map[string]string, timeout time.Duration) ProbeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var res ProbeResult
+	client := &http.Client{}
+
+	// 1. HEAD
+	if resp, err := doProbeRequest(ctx, client, "HEAD", rawURL, headers, ""); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			res.applyHeaders(resp)
+			if resp.ContentLength > 0 {
+				res.Size = resp.ContentLength
+			}
+		}
+	}
+
+	// 2. GET Range：补齐大小、确认 Range 支持并读取文件头
+	resp, err := doProbeRequest(ctx, client, "GET", rawURL, headers, fmt.Sprintf("bytes=0-%d", probeSniffBytes-1))
+	if err != nil {
+		return res
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		res.SupportRange = true
+		if total := parseContentRangeTotal(resp.Header.Get("Content-Range")); total > 0 {
+			res.Size = total
+		}
+	case http.StatusOK:
+		// 服务器忽略了 Range，此时 Content-Length 就是完整大小
+		if res.Size <= 0 && resp.ContentLength > 0 {
+			res.Size = resp.ContentLength
+		}
+	default:
+		return res
+	}
+	res.applyHeaders(resp)
+
+	head := make([]byte, probeSniffBytes)
+	n, _ := io.ReadFull(resp.Body, head)
+	res.DetectedType, res.DetectedExt = DetectMediaSignature(head[:n])
+	return res
+}
+
+// fetchHead 用 Range 请求读取资源开头的字节，服务器忽略 Range 时读够即断开，不会下载整个文件
+func fetchHead(rawURL string, headers map[string]string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := doProbeRequest(ctx, &http.Client{}, "GET", rawURL, headers, fmt.Sprintf("bytes=0-%d", probeSniffBytes-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	head := make([]byte, probeSniffBytes)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+func doProbeRequest(ctx context.Context, client *http.Client, method, rawURL string, headers map[string]string, rangeHeader string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	return client.Do(req)
+}
+
+// applyHeaders 从响应中提取通用字段，已有值不覆盖
+func (r *ProbeResult) applyHeaders(resp *http.Response) {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Accept-Ranges")), "bytes") {
+		r.SupportRange = true
+	}
+	if r.FileName == "" {
+		r.FileName = FilenameFromContentDisposition(resp.Header.Get("Content-Disposition"))
+	}
+	if r.ContentType == "" {
+		r.ContentType = resp.Header.Get("Content-Type")
+	}
+	// resp.Request 是重定向链中的最后一个请求
+	if r.FinalURL == "" && resp.Request != nil && resp.Request.URL != nil {
+		r.FinalURL = resp.Request.URL.String()
+	}
+}
+
+// parseContentRangeTotal 解析 "bytes 0-0/12345" 中的总大小，未知 (*) 返回 0
+func parseContentRangeTotal(v string) int64 {
+	i := strings.LastIndexByte(v, '/')
+	if i < 0 {
+		return 0
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(v[i+1:]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// DetectMediaSignature 根据文件头识别媒体格式，返回任务类型和容器扩展名
+func DetectMediaSignature(head []byte) (taskType, ext string) {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return "hls", ""
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		if len(head) >= 12 && strings.HasPrefix(string(head[8:12]), "M4A") {
+			return "mp4", ".m4a"
+		}
+		return "mp4", ".mp4"
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47:
+		// TS 包长 188 字节，要求连续两个同步字节，避免以 "G" 开头的短文本被误判
+		return "mp4", ".ts"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(head, []byte("webm")) {
+			return "mp4", ".webm"
+		}
+		return "mp4", ".mkv"
+	case bytes.HasPrefix(head, []byte("FLV")):
+		return "mp4", ".flv"
+	}
+	return "", ""
+}
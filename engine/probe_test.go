@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func tsPackets(n int) []byte {
+	data := make([]byte, 188*n)
+	for i := 0; i < n; i++ {
+		data[i*188] = 0x47
+	}
+	return data
+}
+
+func TestDetectMediaSignature(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     []byte
+		wantType string
+		wantExt  string
+	}{
+		{"m3u8", []byte("#EXTM3U\n#EXT-X-VERSION:3\n"), "hls", ""},
+		{"m3u8 带 BOM 与空白", []byte("\xEF\xBB\xBF\r\n  #EXTM3U\n"), "hls", ""},
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), "mp4", ".mp4"},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), "mp4", ".m4a"},
+		{"ts 两个同步字节", tsPackets(2), "mp4", ".ts"},
+		{"ts 第二个包不同步", append([]byte{0x47}, make([]byte, 300)...), "", ""},
+		{"以 G 开头的短文本", []byte("GIF89a"), "", ""},
+		{"以 G 开头的长文本", bytes.Repeat([]byte("G"), 187), "", ""},
+		{"webm", append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x01}, []byte("\x42\x82\x84webm")...), "mp4", ".webm"},
+		{"mkv", append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x01}, []byte("\x42\x82\x88matroska")...), "mp4", ".mkv"},
+		{"flv", []byte("FLV\x01\x05"), "mp4", ".flv"},
+		{"html", []byte("<!DOCTYPE html>"), "", ""},
+		{"空", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, ext := DetectMediaSignature(tt.head)
+			if typ != tt.wantType || ext != tt.wantExt {
+				t.Errorf("DetectMediaSignature = %q %q, want %q %q", typ, ext, tt.wantType, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestFetchHead(t *testing.T) {
+	body := tsPackets(1000)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantLen int
+		wantErr bool
+	}{
+		{"支持 Range", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "bytes=0-1023" || r.Header.Get("Referer") != "https://example.com/" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Range", "bytes 0-1023/188000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body[:probeSniffBytes])
+		}, probeSniffBytes, false},
+		// 忽略 Range 时只读取开头，不下载整个文件
+		{"忽略 Range", func(w http.ResponseWriter, r *http.Request) { w.Write(body) }, probeSniffBytes, false},
+		{"内容不足", func(w http.ResponseWriter, r *http.Request) { w.Write(body[:200]) }, 200, false},
+		{"错误状态", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			head, err := fetchHead(srv.URL, map[string]string{"Referer": "https://example.com/"}, 5*time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(head) != tt.wantLen {
+				t.Fatalf("read %d bytes, want %d", len(head), tt.wantLen)
+			}
+			if !tt.wantErr {
+				if typ, ext := DetectMediaSignature(head); typ != "mp4" || ext != ".ts" {
+					t.Errorf("head not detected as ts: %q %q", typ, ext)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 排序字段
+const (
+	SortByCreated  = "created"
+	SortByStarted  = "started"
+	SortByFinished = "finished"
+	SortByTitle    = "title"
+	SortBySize     = "size"
+	SortByProgress = "progress"
+)
+
+// TaskQuery 任务列表查询条件，零值表示不过滤、按创建时间倒序、不分页
+type TaskQuery struct {
+	Status []string `json:"status"` // 任一匹配即可
+	Type   string   `json:"type"`   // "mp4" / "hls"
+	Host   string   `json:"host"`   // 来源网页或媒体地址的域名（含子域名）
+	Tag    string   `json:"tag"`
+	Search string   `json:"search"` // 在标题和 URL 中搜索，不区分大小写
+	SortBy string   `json:"sortBy"` // 见 SortBy* 常量，默认 created
+	Asc    bool     `json:"asc"`    // 默认倒序
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"` // <= 0 表示不限制
+}
+
+// TaskPage 查询结果
+type TaskPage struct {
+	Items []*VideoTask `json:"items"`
+	Total int          `json:"total"` // 过滤后、分页前的总数
+}
+
+// QueryTasks 过滤、排序并分页返回任务快照
+func (m *Manager) QueryTasks(q TaskQuery) TaskPage {
+	m.mu.RLock()
+	matched := make([]*VideoTask, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		if q.matches(task) {
+			matched = append(matched, task.Clone())
+		}
+	}
+	m.mu.RUnlock()
+
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = SortByCreated
+	}
+	sortTasks(matched, sortBy, !q.Asc)
+
+	page := TaskPage{Total: len(matched)}
+	start := q.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	page.Items = matched[start:end]
+	return page
+}
+
+func (q TaskQuery) matches(t *VideoTask) bool {
+	if len(q.Status) > 0 && !containsString(q.Status, t.Status) {
+		return false
+	}
+	if q.Type != "" && !strings.EqualFold(q.Type, t.Type) {
+		return false
+	}
+	if q.Tag != "" && !containsString(t.Tags, q.Tag) {
+		return false
+	}
+	if q.Host != "" && !hostMatches(t.OriginUrl, q.Host) && !hostMatches(t.Url, q.Host) {
+		return false
+	}
+	if q.Search != "" {
+		kw := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(t.Title), kw) &&
+			!strings.Contains(strings.ToLower(t.Url), kw) &&
+			!strings.Contains(strings.ToLower(t.OriginUrl), kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatches 判断 rawURL 的域名是否等于 host 或为其子域名
+func hostMatches(rawURL, host string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	h := strings.ToLower(u.Hostname())
+	host = strings.ToLower(strings.TrimPrefix(host, "."))
+	return h == host || strings.HasSuffix(h, "."+host)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortTasks 按字段排序，相同时以 ID 兜底保证顺序稳定
+func sortTasks(list []*VideoTask, sortBy string, desc bool) {
+	compare := func(a, b *VideoTask) int {
+		switch sortBy {
+		case SortByStarted:
+			return cmpInt64(a.StartedAt, b.StartedAt)
+		case SortByFinished:
+			return cmpInt64(a.FinishedAt, b.FinishedAt)
+		case SortByTitle:
+			return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		case SortBySize:
+			return cmpInt64(a.Size, b.Size)
+		case SortByProgress:
+			switch {
+			case a.Progress < b.Progress:
+				return -1
+			case a.Progress > b.Progress:
+				return 1
+			}
+			return 0
+		}
+		return cmpInt64(a.CreatedAt, b.CreatedAt)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		c := compare(list[i], list[j])
+		if c == 0 {
+			c = strings.Compare(list[i].ID, list[j].ID)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
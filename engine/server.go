@@ -9,11 +9,12 @@ import (
 
 // ProxyServer 处理本地预览的代理请求
 type ProxyServer struct {
-	Port int
+	Port     int
+	settings *SettingsStore
 }
 
-func NewProxyServer(port int) *ProxyServer {
-	return &ProxyServer{Port: port}
+func NewProxyServer(port int, settings *SettingsStore) *ProxyServer {
+	return &ProxyServer{Port: port, settings: settings}
 }
 
 // Start 启动本地代理服务
@@ -48,7 +49,7 @@ func (s *ProxyServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. 伪造关键请求头，绕过防盗链
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", s.settings.Get().PreviewUserAgent)
 	if referer != "" {
 		req.Header.Set("Referer", referer)
 	} else {
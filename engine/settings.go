@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// SettingsVersion 当前设置文件的结构版本，字段变更时递增并在 migrate 中补齐
+const SettingsVersion = 7
+
+// Settings 用户可配置的全局参数
+type Settings struct {
+	Version          int    `json:"version"`
+	DownloadDir      string `json:"downloadDir"`      // 下载目录，留空则使用 {exeDir}/Downloads
+	CDPPort          int    `json:"cdpPort"`          // 浏览器远程调试端口
+	MaxConcurrency   int    `json:"maxConcurrency"`   // 单个任务的并发下载数
+	ChunkSizeMB      int    `json:"chunkSizeMB"`      // MP4 分块大小 (MB)
+	PreCheckTimeout  int    `json:"preCheckTimeout"`  // 资源预检超时（秒）
+	PreviewUserAgent string `json:"previewUserAgent"` // 本地预览代理使用的 User-Agent
+	FilenameTemplate string `json:"filenameTemplate"` // 输出文件名模板，见 filename.go
+	DeepVerify       bool   `json:"deepVerify"`       // 合并前深度校验所有分片，损坏的自动重下；关闭时合并后保留分片文件，供之后手动校验
+	StorageBackend   string `json:"storageBackend"`   // 任务持久化后端: json / journal，重启后生效
+	BrowserPath      string `json:"browserPath"`      // 指定浏览器可执行文件，留空则自动查找
+	BrowserHeadless  bool   `json:"browserHeadless"`  // 以无界面模式启动浏览器（无人值守嗅探）
+
+	VolatileQueryParams []string `json:"volatileQueryParams"` // 嗅探去重时忽略的查询参数（时间戳、随机数等）
+
+	// 嗅探过滤，用于排除广告片头、悬停预览等；嗅探规则可单独覆盖
+	SniffMinSizeKB       int      `json:"sniffMinSizeKB"`       // 直链最小大小 (KB)，0 表示不限
+	SniffMinDuration     int      `json:"sniffMinDuration"`     // HLS 最小时长（秒），0 表示不限
+	SniffExcludePatterns []string `json:"sniffExcludePatterns"` // URL 排除正则
+	SniffIgnoreNon2xx    bool     `json:"sniffIgnoreNon2xx"`    // 忽略非 2xx 响应
+}
+
+// DefaultSettings 返回出厂默认值
+func DefaultSettings() Settings {
+	return Settings{
+		Version:          SettingsVersion,
+		DownloadDir:      "",
+		CDPPort:          9230,
+		MaxConcurrency:   3,
+		ChunkSizeMB:      50,
+		PreCheckTimeout:  5,
+		PreviewUserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		FilenameTemplate: DefaultFilenameTemplate,
+		StorageBackend:   StoreJSON,
+
+		VolatileQueryParams: append([]string(nil), DefaultVolatileQueryParams...),
+		SniffIgnoreNon2xx:   true,
+	}
+}
+
+// Validate 校验各字段取值范围
+func (s Settings) Validate() error {
+	if s.CDPPort < 1024 || s.CDPPort > 65535 {
+		return fmt.Errorf("调试端口必须在 1024-65535 之间: %d", s.CDPPort)
+	}
+	if s.MaxConcurrency < 1 || s.MaxConcurrency > 32 {
+		return fmt.Errorf("并发数必须在 1-32 之间: %d", s.MaxConcurrency)
+	}
+	if s.ChunkSizeMB < 1 || s.ChunkSizeMB > 1024 {
+		return fmt.Errorf("分块大小必须在 1-1024 MB 之间: %d", s.ChunkSizeMB)
+	}
+	if s.PreCheckTimeout < 1 || s.PreCheckTimeout > 120 {
+		return fmt.Errorf("预检超时必须在 1-120 秒之间: %d", s.PreCheckTimeout)
+	}
+	if strings.TrimSpace(s.PreviewUserAgent) == "" {
+		return fmt.Errorf("预览 User-Agent 不能为空")
+	}
+	if err := ValidateFilenameTemplate(s.FilenameTemplate); err != nil {
+		return err
+	}
+	if s.StorageBackend != StoreJSON && s.StorageBackend != StoreJournal {
+		return fmt.Errorf("未知的存储后端: %s", s.StorageBackend)
+	}
+	if s.DownloadDir != "" && !filepath.IsAbs(s.DownloadDir) {
+		return fmt.Errorf("下载目录必须是绝对路径: %s", s.DownloadDir)
+	}
+	for _, p := range s.VolatileQueryParams {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("易变查询参数名不能为空")
+		}
+	}
+	if s.SniffMinSizeKB < 0 {
+		return fmt.Errorf("最小大小不能为负数: %d", s.SniffMinSizeKB)
+	}
+	if s.SniffMinDuration < 0 {
+		return fmt.Errorf("最小时长不能为负数: %d", s.SniffMinDuration)
+	}
+	if _, err := compilePatterns(s.SniffExcludePatterns); err != nil {
+		return err
+	}
+	if s.BrowserPath != "" {
+		if info, err := os.Stat(s.BrowserPath); err != nil || info.IsDir() {
+			return fmt.Errorf("浏览器路径无效: %s", s.BrowserPath)
+		}
+	}
+	return nil
+}
+
+// ChunkSizeBytes 分块大小（字节）
+func (s Settings) ChunkSizeBytes() int64 {
+	return int64(s.ChunkSizeMB) * 1024 * 1024
+}
+
+// PreCheckTimeoutDuration 预检超时
+func (s Settings) PreCheckTimeoutDuration() time.Duration {
+	return time.Duration(s.PreCheckTimeout) * time.Second
+}
+
+// migrate 将旧版本的设置升级到当前版本，缺失字段使用默认值补齐
+func (s *Settings) migrate() {
+	def := DefaultSettings()
+	if s.CDPPort == 0 {
+		s.CDPPort = def.CDPPort
+	}
+	if s.MaxConcurrency == 0 {
+		s.MaxConcurrency = def.MaxConcurrency
+	}
+	if s.ChunkSizeMB == 0 {
+		s.ChunkSizeMB = def.ChunkSizeMB
+	}
+	if s.PreCheckTimeout == 0 {
+		s.PreCheckTimeout = def.PreCheckTimeout
+	}
+	if s.PreviewUserAgent == "" {
+		s.PreviewUserAgent = def.PreviewUserAgent
+	}
+	// v2: 新增文件名模板
+	if s.Version < 2 || s.FilenameTemplate == "" {
+		s.FilenameTemplate = def.FilenameTemplate
+	}
+	// v3: 新增 DeepVerify，默认关闭，零值即默认值无需补齐
+	// v4: 新增存储后端
+	if s.StorageBackend == "" {
+		s.StorageBackend = def.StorageBackend
+	}
+	// v5: 新增浏览器路径与无界面模式，零值即默认值
+	// v6: 新增嗅探去重忽略的查询参数；用户清空列表（非 nil）时保持为空
+	if s.Version < 6 && s.VolatileQueryParams == nil {
+		s.VolatileQueryParams = def.VolatileQueryParams
+	}
+	// v7: 新增嗅探过滤，大小与时长默认不限，默认忽略非 2xx 响应
+	if s.Version < 7 {
+		s.SniffIgnoreNon2xx = def.SniffIgnoreNon2xx
+	}
+	s.Version = SettingsVersion
+}
+
+// SettingsStore 负责设置的持久化、校验与变更通知
+type SettingsStore struct {
+	ctx       context.Context
+	mu        sync.RWMutex
+	current   Settings
+	listeners []func(Settings)
+	exeDir    string
+	path      string
+}
+
+func NewSettingsStore() *SettingsStore {
+	exePath, _ := os.Executable()
+	exeDir := filepath.Dir(exePath)
+
+	st := &SettingsStore{
+		current: DefaultSettings(),
+		exeDir:  exeDir,
+		path:    filepath.Join(exeDir, "settings.json"),
+	}
+	st.load()
+	return st
+}
+
+func (st *SettingsStore) SetContext(ctx context.Context) {
+	st.ctx = ctx
+}
+
+// Get 返回当前设置的副本
+func (st *SettingsStore) Get() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.current
+}
+
+// Update 校验并保存新设置，成功后通知所有订阅者
+func (st *SettingsStore) Update(next Settings) error {
+	next.Version = SettingsVersion
+	next.DownloadDir = strings.TrimSpace(next.DownloadDir)
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	prev := st.current
+	st.current = next
+	if err := st.save(); err != nil {
+		st.current = prev
+		st.mu.Unlock()
+		return fmt.Errorf("保存设置失败: %v", err)
+	}
+	listeners := append([]func(Settings){}, st.listeners...)
+	st.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(next)
+	}
+	if st.ctx != nil {
+		runtime.EventsEmit(st.ctx, "settings_updated", next)
+	}
+	return nil
+}
+
+// OnChange 注册设置变更回调（在 Update 成功后同步调用）
+func (st *SettingsStore) OnChange(fn func(Settings)) {
+	st.mu.Lock()
+	st.listeners = append(st.listeners, fn)
+	st.mu.Unlock()
+}
+
+// DownloadDir 返回解析后的下载目录绝对路径
+func (st *SettingsStore) DownloadDir() string {
+	dir := st.Get().DownloadDir
+	if dir == "" {
+		dir = filepath.Join(st.exeDir, "Downloads")
+	}
+	return dir
+}
+
+func (st *SettingsStore) load() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	data, err := os.ReadFile(st.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			_ = st.save()
+		}
+		return
+	}
+
+	var loaded Settings
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("设置文件解析失败，使用默认设置: %v", err)
+		return
+	}
+
+	needSave := loaded.Version != SettingsVersion
+	loaded.migrate()
+	if err := loaded.Validate(); err != nil {
+		log.Printf("设置文件校验失败，使用默认设置: %v", err)
+		return
+	}
+
+	st.current = loaded
+	if needSave {
+		_ = st.save()
+	}
+}
+
+// save 写入磁盘，调用方需持有锁
+func (st *SettingsStore) save() error {
+	data, err := json.MarshalIndent(st.current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.path, data, 0644)
+}
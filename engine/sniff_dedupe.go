@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultVolatileQueryParams 默认忽略的易变查询参数（时间戳、防缓存随机数、分段范围等）
+var DefaultVolatileQueryParams = []string{
+	"_", "t", "ts", "timestamp", "time", "rand", "random", "rnd", "nocache", "cb", "cachebuster",
+	"range", "bytes", "rn", "rbuf",
+}
+
+// normalizeMediaURL 规范化媒体 URL 用于去重
+// 主机名小写、去掉片段、去掉易变参数（不区分大小写）并按参数名排序；无法解析时原样返回
+func normalizeMediaURL(raw string, volatile []string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for key := range query {
+		for _, v := range volatile {
+			if strings.EqualFold(key, v) {
+				query.Del(key)
+				break
+			}
+		}
+	}
+	// Encode 会按参数名排序，参数顺序不同的同一地址得到相同结果
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// mediaIDFor 由规范化 URL 生成稳定的媒体 ID，同一资源重复嗅探得到相同 ID
+func mediaIDFor(normalized string) string {
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:6])
+}
+
+// mediaGroup 一个逻辑上的媒体：直链的所有分段请求，或 HLS 主播放列表及其各码率子列表、分片
+type mediaGroup struct {
+	id     string
+	event  *SniffEvent // 首次上报的事件
+	prefix string      // HLS 播放列表所在目录（主机 + 路径），子列表与分片通常位于其下
+	hits   int         // 归入该媒体的请求数
+
+	suppressed bool // 首次上报时被过滤
+}
+
+// sniffDeduper 单个标签页内的嗅探去重
+type sniffDeduper struct {
+	byURL    map[string]*mediaGroup // key 为规范化 URL
+	segments map[string]*mediaGroup // 已解析播放列表中的分片
+	hls      []*mediaGroup
+}
+
+func newSniffDeduper() *sniffDeduper {
+	return &sniffDeduper{
+		byURL:    make(map[string]*mediaGroup),
+		segments: make(map[string]*mediaGroup),
+	}
+}
+
+// segmentExts HLS 分片的常见扩展名
+var segmentExts = map[string]bool{
+	".ts": true, ".m4s": true, ".m4f": true, ".cmfv": true, ".cmfa": true, ".aac": true,
+}
+
+// addPlaylist 记录播放列表中的子列表与分片，之后的请求直接归入该媒体
+func (d *sniffDeduper) addPlaylist(g *mediaGroup, info *PlaylistInfo, volatile []string) {
+	for _, v := range info.Variants {
+		key := normalizeMediaURL(v.URL, volatile)
+		if d.byURL[key] == nil {
+			d.byURL[key] = g
+		}
+	}
+	for _, u := range info.SegmentURLs {
+		d.segments[normalizeMediaURL(u, volatile)] = g
+	}
+}
+
+// claimSegment 判断请求是否为已嗅探播放列表的分片：在播放列表中出现过，
+// 或者扩展名是分片且位于某个播放列表的目录下（直播列表刷新后的新分片）
+func (d *sniffDeduper) claimSegment(rawURL string, volatile []string) bool {
+	key := normalizeMediaURL(rawURL, volatile)
+	g := d.segments[key]
+	if g == nil {
+		u, err := url.Parse(key)
+		if err != nil || !segmentExts[strings.ToLower(path.Ext(u.Path))] {
+			return false
+		}
+		if g = d.playlistOwner(key); g == nil {
+			return false
+		}
+	}
+	g.hits++
+	return true
+}
+
+// admit 将事件归入已有媒体或新建一个，isNew 为 true 时才需要上报
+func (d *sniffDeduper) admit(event *SniffEvent, volatile []string) (g *mediaGroup, isNew bool) {
+	key := normalizeMediaURL(event.Url, volatile)
+	if g := d.byURL[key]; g != nil {
+		g.hits++
+		return g, false
+	}
+	if event.Type == "hls" {
+		if g := d.playlistOwner(key); g != nil {
+			// 同一目录下的其他播放列表视为同一视频的不同码率
+			d.byURL[key] = g
+			g.hits++
+			return g, false
+		}
+	}
+
+	g = &mediaGroup{id: mediaIDFor(key), event: event, hits: 1}
+	d.byURL[key] = g
+	if event.Type == "hls" {
+		g.prefix = playlistPrefix(key)
+		d.hls = append(d.hls, g)
+	}
+	return g, true
+}
+
+// playlistOwner 查找 URL 所属的 HLS 媒体（位于某个已嗅探播放列表的目录下），未找到返回 nil
+func (d *sniffDeduper) playlistOwner(normalized string) *mediaGroup {
+	prefix := playlistPrefix(normalized)
+	if prefix == "" {
+		return nil
+	}
+	// 目录最长（最具体）的优先
+	var owners []*mediaGroup
+	for _, g := range d.hls {
+		if g.prefix != "" && strings.HasPrefix(prefix, g.prefix) {
+			owners = append(owners, g)
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	sort.Slice(owners, func(i, j int) bool { return len(owners[i].prefix) > len(owners[j].prefix) })
+	return owners[0]
+}
+
+// playlistPrefix 取 URL 的主机与目录部分，如 https://cdn.example.com/video/123/
+// 根目录下的播放列表返回空，避免把整个站点的资源都归为一组
+func playlistPrefix(normalized string) string {
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return ""
+	}
+	dir := path.Dir(u.Path)
+	if dir == "/" || dir == "." {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimSuffix(dir, "/") + "/"
+}
+
+// applyPlaylistInfo 将预解析的 m3u8 信息写入事件
+func applyPlaylistInfo(event *SniffEvent, info *PlaylistInfo) {
+	if info.IsMaster {
+		event.Variants = info.Variants
+		if event.Resolution == "" && len(info.Variants) > 0 {
+			event.Resolution = ResolutionLabel(info.Variants[0].Resolution)
+		}
+		return
+	}
+	event.Duration = info.Duration
+	event.SegmentCount = info.SegmentCount
+	event.Encryption = info.Encryption
+	event.IsLive = info.IsLive
+}
+
+// mergeSniffEvent 用同一媒体后续请求带来的信息补齐首次事件的空缺字段，返回是否有变化
+func mergeSniffEvent(dst, src *SniffEvent) bool {
+	changed := false
+	if dst.Size == 0 && src.Size > 0 {
+		// 首次请求没拿到大小时（如 Range 缺失），用后续请求补齐
+		dst.Size = src.Size
+		changed = true
+	}
+	if dst.Resolution == "" && src.Resolution != "" {
+		dst.Resolution = src.Resolution
+		changed = true
+	}
+	if len(dst.Variants) == 0 && len(src.Variants) > 0 {
+		dst.Variants = src.Variants
+		changed = true
+	}
+	if dst.SegmentCount == 0 && src.SegmentCount > 0 {
+		// 主列表本身没有时长，由随后请求的子列表补充
+		dst.Duration = src.Duration
+		dst.SegmentCount = src.SegmentCount
+		dst.Encryption = src.Encryption
+		dst.IsLive = src.IsLive
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,146 @@
+package engine
+
+import "testing"
+
+func TestNormalizeMediaURL(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"易变参数", "https://cdn.example.com/v.mp4?id=1&_=123", "https://cdn.example.com/v.mp4?id=1&_=456", true},
+		{"易变参数不区分大小写", "https://cdn.example.com/v.mp4?id=1&TS=1", "https://cdn.example.com/v.mp4?id=1", true},
+		{"参数顺序", "https://cdn.example.com/v.mp4?a=1&b=2", "https://cdn.example.com/v.mp4?b=2&a=1", true},
+		{"主机大小写", "HTTPS://CDN.Example.com/v.mp4", "https://cdn.example.com/v.mp4", true},
+		{"片段", "https://cdn.example.com/v.mp4#t=10", "https://cdn.example.com/v.mp4", true},
+		{"分段范围", "https://cdn.example.com/v.mp4?range=0-1023", "https://cdn.example.com/v.mp4?range=1024-2047", true},
+		{"路径大小写保留", "https://cdn.example.com/V.mp4", "https://cdn.example.com/v.mp4", false},
+		{"普通参数保留", "https://cdn.example.com/v.mp4?id=1", "https://cdn.example.com/v.mp4?id=2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := normalizeMediaURL(tt.a, DefaultVolatileQueryParams)
+			b := normalizeMediaURL(tt.b, DefaultVolatileQueryParams)
+			if (a == b) != tt.same {
+				t.Errorf("normalize(%q) = %q, normalize(%q) = %q, same want %v", tt.a, a, tt.b, b, tt.same)
+			}
+			if tt.same && mediaIDFor(a) != mediaIDFor(b) {
+				t.Errorf("media ID differs for equal URLs")
+			}
+		})
+	}
+}
+
+func TestPlaylistPrefix(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://cdn.example.com/video/123/index.m3u8", "https://cdn.example.com/video/123/"},
+		{"https://cdn.example.com/video/index.m3u8?token=x", "https://cdn.example.com/video/"},
+		{"https://cdn.example.com/index.m3u8", ""},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := playlistPrefix(tt.url); got != tt.want {
+			t.Errorf("playlistPrefix(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSniffDeduperAdmit(t *testing.T) {
+	vol := DefaultVolatileQueryParams
+	d := newSniffDeduper()
+
+	mp4, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/a.mp4?_=1", Type: "mp4"}, vol)
+	if !isNew {
+		t.Fatal("first mp4 request should be new")
+	}
+	if g, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/a.mp4?_=2", Type: "mp4"}, vol); isNew || g != mp4 || g.hits != 2 {
+		t.Errorf("repeated mp4 request not merged: new=%v hits=%d", isNew, g.hits)
+	}
+
+	master, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/hls/42/master.m3u8", Type: "hls"}, vol)
+	if !isNew {
+		t.Fatal("master playlist should be new")
+	}
+	// 同目录（及子目录）下的其他播放列表归入主列表
+	for _, u := range []string{
+		"https://cdn.example.com/hls/42/720p.m3u8",
+		"https://cdn.example.com/hls/42/1080p/index.m3u8",
+	} {
+		if g, isNew := d.admit(&SniffEvent{Url: u, Type: "hls"}, vol); isNew || g != master {
+			t.Errorf("%s: not merged into master playlist", u)
+		}
+	}
+	// 其他目录是另一个视频
+	if _, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/hls/43/master.m3u8", Type: "hls"}, vol); !isNew {
+		t.Error("playlist in another directory merged")
+	}
+	// 根目录下的播放列表不建立目录归属
+	root, _ := d.admit(&SniffEvent{Url: "https://cdn.example.com/live.m3u8", Type: "hls"}, vol)
+	if root.prefix != "" {
+		t.Errorf("root playlist prefix = %q", root.prefix)
+	}
+	if _, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/other.m3u8", Type: "hls"}, vol); !isNew {
+		t.Error("root-level playlists merged together")
+	}
+}
+
+func TestSniffDeduperMostSpecificOwner(t *testing.T) {
+	vol := DefaultVolatileQueryParams
+	d := newSniffDeduper()
+	// 先嗅探到子目录的播放列表，再出现上级目录的，两者各自成组
+	inner, _ := d.admit(&SniffEvent{Url: "https://cdn.example.com/hls/42/b.m3u8", Type: "hls"}, vol)
+	outer, isNew := d.admit(&SniffEvent{Url: "https://cdn.example.com/hls/a.m3u8", Type: "hls"}, vol)
+	if !isNew || inner == outer {
+		t.Fatal("parent directory playlist merged into nested one")
+	}
+	if got := d.playlistOwner(normalizeMediaURL("https://cdn.example.com/hls/42/seg/1.ts", vol)); got != inner {
+		t.Error("segment not attributed to the most specific playlist")
+	}
+}
+
+func TestSniffDeduperClaimSegment(t *testing.T) {
+	vol := DefaultVolatileQueryParams
+	d := newSniffDeduper()
+	g, _ := d.admit(&SniffEvent{Url: "https://cdn.example.com/hls/42/index.m3u8", Type: "hls"}, vol)
+	d.addPlaylist(g, &PlaylistInfo{
+		Variants:    []HLSVariant{{URL: "https://other.example.com/v/720.m3u8"}},
+		SegmentURLs: []string{"https://seg.example.net/x/000.bin?t=1"},
+	}, vol)
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://seg.example.net/x/000.bin?t=2", true},      // 播放列表中出现过
+		{"https://cdn.example.com/hls/42/009.ts", true},      // 目录下的新分片
+		{"https://cdn.example.com/hls/42/sub/009.m4s", true}, // 子目录
+		{"https://cdn.example.com/hls/42/poster.jpg", false}, // 不是分片扩展名
+		{"https://cdn.example.com/hls/43/009.ts", false},     // 其他目录
+		{"https://seg.example.net/x/001.bin", false},
+	}
+	for _, tt := range tests {
+		if got := d.claimSegment(tt.url, vol); got != tt.want {
+			t.Errorf("claimSegment(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	// 子列表在其他主机上，也归入同一媒体
+	if v, isNew := d.admit(&SniffEvent{Url: "https://other.example.com/v/720.m3u8", Type: "hls"}, vol); isNew || v != g {
+		t.Error("variant listed in master playlist not merged")
+	}
+}
+
+func TestMergeSniffEvent(t *testing.T) {
+	dst := &SniffEvent{Resolution: "720p"}
+	src := &SniffEvent{Size: 100, Resolution: "1080p", Duration: 60, SegmentCount: 10, Encryption: "AES-128"}
+	if !mergeSniffEvent(dst, src) {
+		t.Fatal("merge reported no change")
+	}
+	if dst.Size != 100 || dst.Resolution != "720p" || dst.Duration != 60 || dst.SegmentCount != 10 || dst.Encryption != "AES-128" {
+		t.Errorf("merged = %+v", dst)
+	}
+	if mergeSniffEvent(dst, src) {
+		t.Error("second merge reported a change")
+	}
+}
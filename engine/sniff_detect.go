@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// 嗅探时读取的响应头部字节数，与预检一致（TS 需要第 188 字节）
+const sniffBodyBytes = probeSniffBytes
+
+// mimeClass 响应 Content-Type 的分类结果
+type mimeClass int
+
+const (
+	mimeAmbiguous mimeClass = iota // 通用或缺失的类型，需要看文件头
+	mimeNotMedia                   // 确定不是媒体（图片、页面、脚本等）
+	mimeSegment                    // 媒体分片（TS、fMP4 片段），由播放列表统一处理
+	mimeHLS
+	mimeDASH
+	mimeVideo
+	mimeAudio
+)
+
+// hlsMIMEs HLS 播放列表的常见 Content-Type
+var hlsMIMEs = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"application/mpegurl":           true,
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+}
+
+// ambiguousMIMEs 常被用来返回媒体内容的通用类型
+var ambiguousMIMEs = map[string]bool{
+	"":                           true,
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/binary":         true,
+	"application/download":       true,
+	"application/force-download": true,
+	"text/plain":                 true,
+}
+
+// classifyMIME 根据响应的 Content-Type 判断资源类别
+func classifyMIME(contentType string) mimeClass {
+	mediaType := strings.ToLower(strings.TrimSpace(contentType))
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	}
+	switch {
+	case hlsMIMEs[mediaType]:
+		return mimeHLS
+	case mediaType == "application/dash+xml":
+		return mimeDASH
+	case mediaType == "video/mp2t" || mediaType == "video/iso.segment":
+		return mimeSegment
+	case strings.HasPrefix(mediaType, "video/"):
+		return mimeVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return mimeAudio
+	case ambiguousMIMEs[mediaType]:
+		return mimeAmbiguous
+	}
+	return mimeNotMedia
+}
+
+// sniffBodyType 根据响应开头的字节识别嗅探类型："hls" / "dash" / "mp4"
+// TS 分片返回 segment 为 true；无法识别时 taskType 为空
+func sniffBodyType(head []byte) (taskType string, segment bool) {
+	if isDASHManifest(head) {
+		return "dash", false
+	}
+	taskType, ext := DetectMediaSignature(head)
+	if ext == ".ts" {
+		return "", true
+	}
+	return taskType, false
+}
+
+// isDASHManifest 判断是否为 DASH 的 MPD 清单
+func isDASHManifest(head []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(trimmed, []byte("<MPD"))
+}
+
+// typeForMIME 媒体类别对应的嗅探类型，音频与视频直链都按 mp4（整文件下载）处理
+func typeForMIME(class mimeClass) string {
+	switch class {
+	case mimeHLS:
+		return "hls"
+	case mimeDASH:
+		return "dash"
+	case mimeVideo, mimeAudio:
+		return "mp4"
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// 嗅探结果被过滤的原因
+const (
+	SuppressExcluded = "excluded" // URL 命中排除规则
+	SuppressStatus   = "status"   // 非 2xx 响应
+	SuppressSize     = "size"     // 小于最小大小
+	SuppressDuration = "duration" // HLS 时长小于最小时长
+)
+
+// SniffSuppressed 被过滤的嗅探结果，通过 sniff_suppressed 事件通知前端
+type SniffSuppressed struct {
+	TargetID  string `json:"targetId"`
+	MediaID   string `json:"mediaId"`
+	Url       string `json:"url"`
+	Reason    string `json:"reason"`
+	Retracted bool   `json:"retracted"` // 已上报过，后续补齐信息（如主列表的时长）后才被过滤，前端需移除
+	Count     int64  `json:"count"`     // 该标签页累计过滤数
+	Total     int64  `json:"total"`     // 本次运行累计过滤数
+}
+
+// sniffFilter 合并全局设置与规则后的过滤条件
+type sniffFilter struct {
+	minSize      int64
+	minDuration  float64
+	ignoreNon2xx bool
+	excludes     []*regexp.Regexp
+}
+
+// compilePatterns 编译 URL 排除规则，任一无效时返回错误
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("URL 排除规则无效 %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// filterFor 返回适用于某条规则（可为空）的过滤条件
+// 规则中设置的最小大小、最小时长与非 2xx 开关覆盖全局设置，排除规则则在全局基础上追加
+func (s *Sniffer) filterFor(rule *SniffRule) sniffFilter {
+	cfg := s.settings.Get()
+	s.mu.Lock()
+	f := sniffFilter{
+		minSize:      int64(cfg.SniffMinSizeKB) * 1024,
+		minDuration:  float64(cfg.SniffMinDuration),
+		ignoreNon2xx: cfg.SniffIgnoreNon2xx,
+		excludes:     s.excludes,
+	}
+	s.mu.Unlock()
+
+	if rule == nil {
+		return f
+	}
+	if rule.MinSizeKB != nil {
+		f.minSize = int64(*rule.MinSizeKB) * 1024
+	}
+	if rule.MinDuration != nil {
+		f.minDuration = *rule.MinDuration
+	}
+	if rule.IgnoreNon2xx != nil {
+		f.ignoreNon2xx = *rule.IgnoreNon2xx
+	}
+	if len(rule.excludeRes) > 0 {
+		f.excludes = append(append([]*regexp.Regexp(nil), f.excludes...), rule.excludeRes...)
+	}
+	return f
+}
+
+// check 返回事件被过滤的原因，通过时返回空
+// 大小与时长未知（为 0）时不过滤；直播没有固定时长，也不按时长过滤
+func (f sniffFilter) check(event *SniffEvent) string {
+	for _, re := range f.excludes {
+		if re.MatchString(event.Url) {
+			return SuppressExcluded
+		}
+	}
+	if f.ignoreNon2xx && event.StatusCode != 0 && (event.StatusCode < 200 || event.StatusCode > 299) {
+		return SuppressStatus
+	}
+	if event.Type != "hls" && f.minSize > 0 && event.Size > 0 && event.Size < f.minSize {
+		return SuppressSize
+	}
+	if event.Type == "hls" && !event.IsLive && f.minDuration > 0 && event.Duration > 0 && event.Duration < f.minDuration {
+		return SuppressDuration
+	}
+	return ""
+}
+
+// updateExcludes 设置变更后重新编译全局排除规则（设置保存前已校验过）
+func (s *Sniffer) updateExcludes(cfg Settings) {
+	res, err := compilePatterns(cfg.SniffExcludePatterns)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.excludes = res
+	s.mu.Unlock()
+}
+
+// suppress 记录一个被过滤的结果并通知前端，retracted 表示该结果之前已上报过
+func (s *Sniffer) suppress(event *SniffEvent, reason string, retracted bool, tabCount int64) {
+	s.mu.Lock()
+	s.suppressed++
+	total := s.suppressed
+	s.mu.Unlock()
+	s.manager.emitEvent("sniff_suppressed", SniffSuppressed{
+		TargetID:  event.TargetID,
+		MediaID:   event.MediaID,
+		Url:       event.Url,
+		Reason:    reason,
+		Retracted: retracted,
+		Count:     tabCount,
+		Total:     total,
+	})
+}
+
+// SuppressedCount 本次运行累计过滤的嗅探结果数
+func (s *Sniffer) SuppressedCount() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suppressed
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSniffFilterCheck(t *testing.T) {
+	f := sniffFilter{
+		minSize:      100 * 1024,
+		minDuration:  30,
+		ignoreNon2xx: true,
+		excludes:     []*regexp.Regexp{regexp.MustCompile(`/ads/`)},
+	}
+	tests := []struct {
+		name  string
+		event SniffEvent
+		want  string
+	}{
+		{"通过", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", StatusCode: 200, Size: 1 << 20}, ""},
+		{"排除规则", SniffEvent{Url: "https://cdn.example.com/ads/v.mp4", Type: "mp4", StatusCode: 200, Size: 1 << 20}, SuppressExcluded},
+		{"非 2xx", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", StatusCode: 403}, SuppressStatus},
+		{"206 属于 2xx", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", StatusCode: 206, Size: 1 << 20}, ""},
+		{"状态码未知", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", Size: 1 << 20}, ""},
+		{"过小", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", StatusCode: 200, Size: 1024}, SuppressSize},
+		{"大小未知", SniffEvent{Url: "https://cdn.example.com/v.mp4", Type: "mp4", StatusCode: 200}, ""},
+		{"HLS 不按大小过滤", SniffEvent{Url: "https://cdn.example.com/a.m3u8", Type: "hls", StatusCode: 200, Size: 512, Duration: 60}, ""},
+		{"HLS 过短", SniffEvent{Url: "https://cdn.example.com/a.m3u8", Type: "hls", StatusCode: 200, Duration: 10}, SuppressDuration},
+		{"HLS 时长未知（主列表）", SniffEvent{Url: "https://cdn.example.com/a.m3u8", Type: "hls", StatusCode: 200}, ""},
+		{"直播不按时长过滤", SniffEvent{Url: "https://cdn.example.com/a.m3u8", Type: "hls", StatusCode: 200, Duration: 10, IsLive: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.check(&tt.event); got != tt.want {
+				t.Errorf("check = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	relaxed := sniffFilter{}
+	if got := relaxed.check(&SniffEvent{Url: "https://cdn.example.com/v.mp4", StatusCode: 404, Size: 1}); got != "" {
+		t.Errorf("zero filter suppressed event: %q", got)
+	}
+}
+
+func TestSnifferFilterFor(t *testing.T) {
+	cfg := DefaultSettings()
+	cfg.SniffMinSizeKB = 100
+	cfg.SniffMinDuration = 30
+	cfg.SniffIgnoreNon2xx = true
+	// 预留容量：若直接在全局切片上追加会写进共享的底层数组
+	global := make([]*regexp.Regexp, 1, 4)
+	global[0] = regexp.MustCompile(`/ads/`)
+	s := &Sniffer{settings: &SettingsStore{current: cfg}, excludes: global}
+
+	minSize, minDuration, ignore := 0, 5.5, false
+	rule := &SniffRule{
+		Name:            "site",
+		MinSizeKB:       &minSize,
+		MinDuration:     &minDuration,
+		IgnoreNon2xx:    &ignore,
+		ExcludePatterns: []string{`/preview/`},
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		rule        *SniffRule
+		minSize     int64
+		minDuration float64
+		ignore      bool
+		excludes    int
+	}{
+		{"无规则用全局设置", nil, 100 * 1024, 30, true, 1},
+		{"规则未设置过滤条件", &SniffRule{Name: "plain"}, 100 * 1024, 30, true, 1},
+		{"规则覆盖全局设置并追加排除", rule, 0, 5.5, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := s.filterFor(tt.rule)
+			if f.minSize != tt.minSize || f.minDuration != tt.minDuration || f.ignoreNon2xx != tt.ignore || len(f.excludes) != tt.excludes {
+				t.Errorf("filter = %+v", f)
+			}
+		})
+	}
+
+	// 追加规则排除项不能改写全局切片
+	s.filterFor(rule)
+	if len(s.excludes) != 1 || global[:2][1] != nil {
+		t.Errorf("global excludes modified: %v", s.excludes)
+	}
+	if got := s.filterFor(rule).check(&SniffEvent{Url: "https://cdn.example.com/preview/v.mp4"}); got != SuppressExcluded {
+		t.Errorf("rule exclude not applied: %q", got)
+	}
+}
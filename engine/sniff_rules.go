@@ -0,0 +1,287 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// SniffRule 定义单个嗅探规则
+// 规则按 Priority 从高到低匹配（相同优先级按文件中的顺序），第一条命中的规则生效；
+// 命中 Exclude 规则的请求不会被嗅探
+type SniffRule struct {
+	Name           string   `json:"name"`
+	HostKeyword    string   `json:"host_keyword"`    // URL 必须包含的域名片段 (如 "yyyyy")
+	TargetReferer  string   `json:"target_referer"`  // 可选：来源页面必须包含的关键词
+	MustContain    string   `json:"must_contain"`    // 可选：URL 必须包含的后缀或路径 (如 ".mp4")
+	UrlRegex       string   `json:"url_regex"`       // 新增：支持正则表达式
+	CaptureHeaders []string `json:"capture_headers"` // 需要抓取的 Header 列表
+
+	FilenameTemplate string `json:"filename_template"` // 可选：覆盖全局文件名模板
+
+	Priority      int               `json:"priority,omitempty"`       // 优先级，越大越先匹配
+	Exclude       bool              `json:"exclude,omitempty"`        // 排除规则：命中的请求直接忽略
+	Rewrites      []URLRewrite      `json:"rewrites,omitempty"`       // 依次应用的 URL 改写
+	InjectHeaders map[string]string `json:"inject_headers,omitempty"` // 固定附加的请求头，覆盖抓取到的同名头
+	Type          string            `json:"type,omitempty"`           // 强制指定类型: hls / mp4
+	OutputDir     string            `json:"output_dir,omitempty"`     // 输出目录，相对路径基于下载目录
+
+	// 可选：过滤条件，未设置时使用全局设置
+	MinSizeKB       *int     `json:"min_size_kb,omitempty"`      // 直链最小大小 (KB)
+	MinDuration     *float64 `json:"min_duration,omitempty"`     // HLS 最小时长（秒）
+	IgnoreNon2xx    *bool    `json:"ignore_non_2xx,omitempty"`   // 是否忽略非 2xx 响应
+	ExcludePatterns []string `json:"exclude_patterns,omitempty"` // URL 排除正则，在全局排除规则基础上追加
+
+	urlRe      *regexp.Regexp
+	excludeRes []*regexp.Regexp
+}
+
+// URLRewrite URL 改写
+// Match 为正则，Replace 为替换模板（支持 $1、${name}），例如把 _480p 换成 _1080p；
+// RemoveParams 删除指定的查询参数，例如去掉 start= 以下载完整视频
+type URLRewrite struct {
+	Match        string   `json:"match,omitempty"`
+	Replace      string   `json:"replace,omitempty"`
+	RemoveParams []string `json:"remove_params,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile 校验规则并预编译其中的正则，之后匹配时不再重复编译
+func (r *SniffRule) compile() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("规则名称不能为空")
+	}
+	if r.UrlRegex != "" {
+		re, err := regexp.Compile(r.UrlRegex)
+		if err != nil {
+			return fmt.Errorf("规则 %s: url_regex 无效: %v", r.Name, err)
+		}
+		r.urlRe = re
+	} else {
+		r.urlRe = nil
+	}
+	res, err := compilePatterns(r.ExcludePatterns)
+	if err != nil {
+		return fmt.Errorf("规则 %s: %v", r.Name, err)
+	}
+	r.excludeRes = res
+
+	for i := range r.Rewrites {
+		rw := &r.Rewrites[i]
+		rw.re = nil
+		if rw.Match == "" && len(rw.RemoveParams) == 0 {
+			return fmt.Errorf("规则 %s: 第 %d 个改写既没有 match 也没有 remove_params", r.Name, i+1)
+		}
+		if rw.Match != "" {
+			re, err := regexp.Compile(rw.Match)
+			if err != nil {
+				return fmt.Errorf("规则 %s: 第 %d 个改写的 match 无效: %v", r.Name, i+1, err)
+			}
+			rw.re = re
+		}
+	}
+
+	switch r.Type {
+	case "", "hls", "mp4":
+	default:
+		return fmt.Errorf("规则 %s: 未知的类型 %q（可选 hls / mp4）", r.Name, r.Type)
+	}
+	for k := range r.InjectHeaders {
+		if strings.TrimSpace(k) == "" || strings.ContainsAny(k, " :\r\n") {
+			return fmt.Errorf("规则 %s: 无效的请求头名 %q", r.Name, k)
+		}
+	}
+	if r.OutputDir != "" && !filepath.IsAbs(r.OutputDir) {
+		clean := filepath.Clean(r.OutputDir)
+		if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("规则 %s: 输出目录不能位于下载目录之外: %s", r.Name, r.OutputDir)
+		}
+	}
+	return nil
+}
+
+// clone 深拷贝规则，预编译的正则只读，可以共享
+func (r *SniffRule) clone() *SniffRule {
+	c := *r
+	c.CaptureHeaders = append([]string(nil), r.CaptureHeaders...)
+	c.ExcludePatterns = append([]string(nil), r.ExcludePatterns...)
+	c.excludeRes = append([]*regexp.Regexp(nil), r.excludeRes...)
+	c.Rewrites = append([]URLRewrite(nil), r.Rewrites...)
+	for i := range c.Rewrites {
+		c.Rewrites[i].RemoveParams = append([]string(nil), r.Rewrites[i].RemoveParams...)
+	}
+	if r.InjectHeaders != nil {
+		c.InjectHeaders = make(map[string]string, len(r.InjectHeaders))
+		for k, v := range r.InjectHeaders {
+			c.InjectHeaders[k] = v
+		}
+	}
+	if r.MinSizeKB != nil {
+		v := *r.MinSizeKB
+		c.MinSizeKB = &v
+	}
+	if r.MinDuration != nil {
+		v := *r.MinDuration
+		c.MinDuration = &v
+	}
+	if r.IgnoreNon2xx != nil {
+		v := *r.IgnoreNon2xx
+		c.IgnoreNon2xx = &v
+	}
+	return &c
+}
+
+// matches 判断请求是否满足规则的全部条件
+func (r *SniffRule) matches(url, docUrl string) bool {
+	if r.HostKeyword != "" && !strings.Contains(url, r.HostKeyword) {
+		return false
+	}
+	if r.MustContain != "" && !strings.Contains(url, r.MustContain) {
+		return false
+	}
+	if r.TargetReferer != "" && !strings.Contains(docUrl, r.TargetReferer) {
+		return false
+	}
+	if r.urlRe != nil && !r.urlRe.MatchString(url) {
+		return false
+	}
+	return true
+}
+
+// RewriteURL 依次应用规则中的 URL 改写，没有改写时原样返回
+func (r *SniffRule) RewriteURL(raw string) string {
+	for _, rw := range r.Rewrites {
+		if rw.re != nil {
+			raw = rw.re.ReplaceAllString(raw, rw.Replace)
+		}
+		if len(rw.RemoveParams) > 0 {
+			raw = removeQueryParams(raw, rw.RemoveParams)
+		}
+	}
+	return raw
+}
+
+// removeQueryParams 删除查询参数，保持其余参数的原始顺序与编码
+func removeQueryParams(raw string, names []string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	var kept []string
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		drop := false
+		for _, name := range names {
+			if key == name {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, part)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	return u.String()
+}
+
+// ResolveOutputDir 规则指定的输出目录，相对路径基于 downloadDir；未指定返回 downloadDir
+func (r *SniffRule) ResolveOutputDir(downloadDir string) string {
+	if r == nil || r.OutputDir == "" {
+		return downloadDir
+	}
+	if filepath.IsAbs(r.OutputDir) {
+		return r.OutputDir
+	}
+	return filepath.Join(downloadDir, r.OutputDir)
+}
+
+// InjectsHeader 规则是否固定附加了该请求头（不区分大小写）
+func (r *SniffRule) InjectsHeader(name string) bool {
+	if r == nil {
+		return false
+	}
+	for k := range r.InjectHeaders {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortRules 按优先级从高到低排列，相同优先级保持原有顺序
+func sortRules(rules []SniffRule) {
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+}
+
+// matchRule 返回第一条命中的规则（可能是排除规则），没有命中返回 nil
+// 规则只会整体替换，不会原地修改，返回的指针在规则重新加载后仍然可用
+func (s *Sniffer) matchRule(url, docUrl string) *SniffRule {
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+	for i := range s.rules {
+		if r := &s.rules[i]; r.matches(url, docUrl) {
+			return r
+		}
+	}
+	return nil
+}
+
+// GetRule 按名称查找规则，返回副本；未找到返回 nil
+func (s *Sniffer) GetRule(name string) *SniffRule {
+	if name == "" {
+		return nil
+	}
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+	for i := range s.rules {
+		if s.rules[i].Name == name {
+			return s.rules[i].clone()
+		}
+	}
+	return nil
+}
+
+// filterHeaders 按规则抓取请求头并附加规则中的固定请求头；无规则时保留 Referer、Cookie、User-Agent
+// requestWillBeSentExtraInfo 中的 HTTP/2 头为小写，均按不区分大小写匹配
+func (s *Sniffer) filterHeaders(cdpHeaders network.Headers, rule *SniffRule) map[string]string {
+	allHeaders := make(map[string]string)
+	for k, v := range cdpHeaders {
+		allHeaders[k] = fmt.Sprintf("%v", v)
+	}
+
+	keys := []string{"Referer", "Cookie", "User-Agent"}
+	if rule != nil {
+		keys = rule.CaptureHeaders
+	}
+	finalHeaders := make(map[string]string)
+	for _, key := range keys {
+		for k, v := range allHeaders {
+			if strings.EqualFold(k, key) {
+				finalHeaders[key] = v
+				break
+			}
+		}
+	}
+
+	if rule != nil {
+		for k, v := range rule.InjectHeaders {
+			for existing := range finalHeaders {
+				if strings.EqualFold(existing, k) {
+					delete(finalHeaders, existing)
+				}
+			}
+			finalHeaders[k] = v
+		}
+	}
+	return finalHeaders
+}
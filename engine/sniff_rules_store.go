@@ -0,0 +1,420 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// rulesPollInterval 检查规则文件是否被外部修改的间隔
+const rulesPollInterval = 2 * time.Second
+
+// RuleSet 当前生效的规则及最近一次加载的情况，通过 sniff_rules 事件推送给前端
+type RuleSet struct {
+	Path     string      `json:"path"`
+	Rules    []SniffRule `json:"rules"`
+	Warnings []string    `json:"warnings"`        // 被跳过的规则、未知字段等
+	Error    string      `json:"error,omitempty"` // 规则文件无法解析时的原因，此时沿用之前的规则
+}
+
+// RuleTestRequest 规则测试输入
+type RuleTestRequest struct {
+	Url     string            `json:"url"`
+	PageUrl string            `json:"pageUrl"`
+	Headers map[string]string `json:"headers"`
+	Rule    *SniffRule        `json:"rule,omitempty"` // 可选：测试尚未保存的规则，为空时使用当前规则
+}
+
+// RuleTestResult 规则测试结果，与实际嗅探时的处理一致
+type RuleTestResult struct {
+	Matched      bool              `json:"matched"`
+	RuleName     string            `json:"ruleName"`
+	Excluded     bool              `json:"excluded"` // 命中排除规则，该请求不会被嗅探
+	Headers      map[string]string `json:"headers"`  // 抓取并附加固定请求头后的结果
+	RewrittenUrl string            `json:"rewrittenUrl"`
+	Type         string            `json:"type"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// rulesStamp 规则文件的修改时间与大小，用于判断是否需要重新加载
+type rulesStamp struct {
+	path string
+	mod  time.Time
+	size int64
+}
+
+func statRules(path string) rulesStamp {
+	st := rulesStamp{path: path}
+	if info, err := os.Stat(path); err == nil {
+		st.mod, st.size = info.ModTime(), info.Size()
+	}
+	return st
+}
+
+// validate 在 compile 的基础上做更严格的检查，保存与测试规则时使用
+// 已有规则文件中不满足这些检查的规则仍会加载，只给出警告
+func (r *SniffRule) validate() error {
+	if err := r.compile(); err != nil {
+		return err
+	}
+	if r.HostKeyword == "" && r.MustContain == "" && r.UrlRegex == "" && r.TargetReferer == "" {
+		return fmt.Errorf("规则 %s: 至少需要设置 host_keyword、must_contain、url_regex、target_referer 之一，否则会匹配所有请求", r.Name)
+	}
+	for _, h := range r.CaptureHeaders {
+		if strings.TrimSpace(h) == "" || strings.ContainsAny(h, " :\r\n") {
+			return fmt.Errorf("规则 %s: 无效的抓取请求头名 %q", r.Name, h)
+		}
+	}
+	if r.FilenameTemplate != "" {
+		if err := ValidateFilenameTemplate(r.FilenameTemplate); err != nil {
+			return fmt.Errorf("规则 %s: %v", r.Name, err)
+		}
+	}
+	if r.MinSizeKB != nil && *r.MinSizeKB < 0 {
+		return fmt.Errorf("规则 %s: min_size_kb 不能为负数", r.Name)
+	}
+	if r.MinDuration != nil && *r.MinDuration < 0 {
+		return fmt.Errorf("规则 %s: min_duration 不能为负数", r.Name)
+	}
+	return nil
+}
+
+// rulesPath 规则文件路径，尚不存在时使用 {exeDir}/bin/config/sniff_rules.json
+func (s *Sniffer) rulesPath() string {
+	if path := s.env.GetRulesPath(); path != "" {
+		return path
+	}
+	return filepath.Join(s.env.GetExeDir(), "bin", "config", "sniff_rules.json")
+}
+
+// lineCol 将字节偏移换算为行号与列号（从 1 开始）
+func lineCol(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// describeJSONError 将 JSON 解析错误转换为可读的说明，data 不为空时附带出错位置
+func describeJSONError(data []byte, err error) string {
+	var syn *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	var msg string
+	var offset int64 = -1
+	switch {
+	case errors.As(err, &syn):
+		msg, offset = syn.Error(), syn.Offset
+	case errors.As(err, &typ):
+		offset = typ.Offset
+		if typ.Field == "" {
+			msg = fmt.Sprintf("顶层应为规则数组，实际为 %s", typ.Value)
+		} else {
+			msg = fmt.Sprintf("字段 %s 应为 %s，实际为 %s", typ.Field, typ.Type, typ.Value)
+		}
+	default:
+		msg = strings.TrimPrefix(err.Error(), "json: ")
+	}
+	if data != nil && offset >= 0 {
+		line, col := lineCol(data, offset)
+		return fmt.Sprintf("第 %d 行第 %d 列: %s", line, col, msg)
+	}
+	return msg
+}
+
+// readRuleEntries 读取规则文件中的原始条目，文件不存在或为空时返回空
+// 保存时直接修改原始条目，无效的规则与未知字段原样保留，不会因为在界面上编辑别的规则而丢失
+func readRuleEntries(path string) ([]json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取规则文件失败: %v", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("规则文件格式错误: %s", describeJSONError(data, err))
+	}
+	return entries, nil
+}
+
+// entryName 读取原始条目的规则名称，无法解析时返回空
+func entryName(raw json.RawMessage) string {
+	var v struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(raw, &v)
+	return v.Name
+}
+
+// decodeRule 解析并编译单条规则；未知字段与 validate 中的问题作为警告返回，规则仍然有效
+func decodeRule(raw json.RawMessage) (SniffRule, []string, error) {
+	var r SniffRule
+	var warnings []string
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		if !strings.HasPrefix(err.Error(), "json: unknown field") {
+			return r, nil, fmt.Errorf("%s", describeJSONError(nil, err))
+		}
+		warnings = append(warnings, "未知字段 "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+		r = SniffRule{}
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return r, nil, fmt.Errorf("%s", describeJSONError(nil, err))
+		}
+	}
+	if err := r.compile(); err != nil {
+		return r, nil, err
+	}
+	if err := r.validate(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	return r, warnings, nil
+}
+
+// parseRules 解析规则条目，无效的规则跳过并记录原因；同名规则只保留第一条
+func parseRules(entries []json.RawMessage) ([]SniffRule, []string) {
+	rules := make([]SniffRule, 0, len(entries))
+	warnings := []string{}
+	seen := make(map[string]bool)
+	for i, raw := range entries {
+		r, warns, err := decodeRule(raw)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("第 %d 条规则已跳过: %v", i+1, err))
+			continue
+		}
+		for _, w := range warns {
+			warnings = append(warnings, fmt.Sprintf("第 %d 条规则 (%s): %s", i+1, r.Name, w))
+		}
+		if seen[r.Name] {
+			warnings = append(warnings, fmt.Sprintf("第 %d 条规则已跳过: 名称 %s 与前面的规则重复", i+1, r.Name))
+			continue
+		}
+		seen[r.Name] = true
+		rules = append(rules, r)
+	}
+	sortRules(rules)
+	return rules, warnings
+}
+
+// ReloadRules 重新读取规则文件
+// 文件格式错误时保留当前规则并返回错误，单条规则无效时跳过该规则并记入警告
+func (s *Sniffer) ReloadRules() error {
+	s.rulesFileMu.Lock()
+	defer s.rulesFileMu.Unlock()
+	return s.reloadRulesLocked()
+}
+
+// reloadRulesLocked 需持有 rulesFileMu
+func (s *Sniffer) reloadRulesLocked() error {
+	path := s.rulesPath()
+	stamp := statRules(path)
+	entries, err := readRuleEntries(path)
+
+	s.rulesMu.Lock()
+	s.rulesStamp = stamp
+	if err != nil {
+		s.rulesError = err.Error()
+	} else {
+		s.rules, s.ruleWarnings = parseRules(entries)
+		s.rulesError = ""
+	}
+	set := s.ruleSetLocked()
+	s.rulesMu.Unlock()
+
+	if err != nil {
+		log.Printf("加载嗅探规则失败，沿用之前的规则: %v", err)
+	} else {
+		for _, w := range set.Warnings {
+			log.Printf("嗅探规则: %s", w)
+		}
+	}
+	s.manager.emitEvent("sniff_rules", set)
+	return err
+}
+
+// ruleSetLocked 需持有 rulesMu
+func (s *Sniffer) ruleSetLocked() RuleSet {
+	set := RuleSet{
+		Path:     s.rulesStamp.path,
+		Rules:    make([]SniffRule, 0, len(s.rules)),
+		Warnings: append([]string{}, s.ruleWarnings...),
+		Error:    s.rulesError,
+	}
+	for i := range s.rules {
+		set.Rules = append(set.Rules, *s.rules[i].clone())
+	}
+	return set
+}
+
+// ListRules 返回当前生效的规则（按匹配顺序）与最近一次加载的情况
+func (s *Sniffer) ListRules() RuleSet {
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+	return s.ruleSetLocked()
+}
+
+// SaveRule 校验并保存规则，originalName 为空表示新增，否则替换该名称的规则（可同时改名）
+func (s *Sniffer) SaveRule(rule SniffRule, originalName string) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	return s.updateRuleFile(func(entries []json.RawMessage) ([]json.RawMessage, error) {
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return nil, err
+		}
+		idx := -1
+		for i, raw := range entries {
+			name := entryName(raw)
+			if originalName != "" && name == originalName {
+				idx = i
+			} else if name == rule.Name {
+				return nil, fmt.Errorf("已存在名为 %s 的规则", rule.Name)
+			}
+		}
+		if originalName != "" && idx < 0 {
+			return nil, fmt.Errorf("规则 %s 不存在，可能已被删除或改名", originalName)
+		}
+		if idx < 0 {
+			return append(entries, data), nil
+		}
+		entries[idx] = data
+		return entries, nil
+	})
+}
+
+// DeleteRule 按名称删除规则
+func (s *Sniffer) DeleteRule(name string) error {
+	return s.updateRuleFile(func(entries []json.RawMessage) ([]json.RawMessage, error) {
+		kept := entries[:0]
+		for _, raw := range entries {
+			if entryName(raw) != name {
+				kept = append(kept, raw)
+			}
+		}
+		if len(kept) == len(entries) {
+			return nil, fmt.Errorf("规则 %s 不存在", name)
+		}
+		return kept, nil
+	})
+}
+
+// updateRuleFile 读取规则文件、修改后原子写回并重新加载
+// 文件格式错误时拒绝修改，避免覆盖用户手工编辑到一半的内容
+func (s *Sniffer) updateRuleFile(edit func([]json.RawMessage) ([]json.RawMessage, error)) error {
+	s.rulesFileMu.Lock()
+	defer s.rulesFileMu.Unlock()
+
+	path := s.rulesPath()
+	entries, err := readRuleEntries(path)
+	if err != nil {
+		return fmt.Errorf("%v，请先修正规则文件", err)
+	}
+	if entries == nil {
+		entries = []json.RawMessage{}
+	}
+	if entries, err = edit(entries); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建规则目录失败: %v", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("保存规则文件失败: %v", err)
+	}
+	return s.reloadRulesLocked()
+}
+
+// watchRules 定期检查规则文件，被外部修改（或新建、删除）后自动重新加载
+// 没有依赖文件系统通知，编辑器先删除再重命名的保存方式也能正确处理
+func (s *Sniffer) watchRules() {
+	ticker := time.NewTicker(rulesPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		stamp := statRules(s.rulesPath())
+		s.rulesMu.RLock()
+		changed := stamp != s.rulesStamp
+		s.rulesMu.RUnlock()
+		if changed {
+			log.Printf("嗅探规则文件已变化，重新加载: %s", stamp.path)
+			s.ReloadRules()
+		}
+	}
+}
+
+// TestRule 用给定的 URL、页面地址与请求头模拟一次嗅探，返回命中的规则、抓取的请求头与改写后的 URL
+func (s *Sniffer) TestRule(req RuleTestRequest) RuleTestResult {
+	res := RuleTestResult{RewrittenUrl: req.Url, Type: s.getURLType(req.Url)}
+	if strings.TrimSpace(req.Url) == "" {
+		res.Error = "请输入要测试的 URL"
+		return res
+	}
+	headers := make(network.Headers, len(req.Headers))
+	for k, v := range req.Headers {
+		headers[k] = v
+	}
+
+	var rule *SniffRule
+	if req.Rule != nil {
+		r := req.Rule.clone()
+		r.Name = strings.TrimSpace(r.Name)
+		if err := r.validate(); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		if r.matches(req.Url, req.PageUrl) {
+			rule = r
+		}
+	} else {
+		rule = s.matchRule(req.Url, req.PageUrl)
+	}
+
+	if rule == nil {
+		res.Headers = s.filterHeaders(headers, nil)
+		return res
+	}
+	res.Matched, res.RuleName = true, rule.Name
+	if rule.Exclude {
+		res.Excluded = true
+		res.Headers = map[string]string{}
+		return res
+	}
+	res.Headers = s.filterHeaders(headers, rule)
+	res.RewrittenUrl = rule.RewriteURL(req.Url)
+	if rule.Type != "" {
+		res.Type = rule.Type
+	}
+	return res
+}
+
+// Close 程序退出时停止嗅探与规则文件监视
+func (s *Sniffer) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.Stop(false)
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newRulesTestSniffer(t *testing.T) *Sniffer {
+	t.Helper()
+	return &Sniffer{env: &EnvResolver{exeDir: t.TempDir()}, manager: &Manager{}, closed: make(chan struct{})}
+}
+
+func writeRulesFile(t *testing.T, s *Sniffer, content string) {
+	t.Helper()
+	path := s.rulesPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSniffRuleValidate(t *testing.T) {
+	neg, negF := -1, -1.0
+	tests := []struct {
+		name    string
+		rule    SniffRule
+		wantErr string
+	}{
+		{"有效", SniffRule{Name: "a", HostKeyword: "cdn"}, ""},
+		{"没有匹配条件", SniffRule{Name: "a"}, "至少需要设置"},
+		{"compile 错误", SniffRule{Name: "a", HostKeyword: "cdn", Type: "flv"}, "未知的类型"},
+		{"抓取请求头名无效", SniffRule{Name: "a", HostKeyword: "cdn", CaptureHeaders: []string{"Bad Header"}}, "抓取请求头名"},
+		{"文件名模板无效", SniffRule{Name: "a", HostKeyword: "cdn", FilenameTemplate: "{nope}"}, "规则 a"},
+		{"最小大小为负", SniffRule{Name: "a", HostKeyword: "cdn", MinSizeKB: &neg}, "min_size_kb"},
+		{"最小时长为负", SniffRule{Name: "a", HostKeyword: "cdn", MinDuration: &negF}, "min_duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDescribeJSONError(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"语法错误", "[\n  {\"name\": \"a\",}\n]", "第 2 行第 "},
+		{"顶层不是数组", `{"name": "a"}`, "顶层应为规则数组"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []json.RawMessage
+			err := json.Unmarshal([]byte(tt.data), &entries)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := describeJSONError([]byte(tt.data), err); !strings.Contains(got, tt.want) {
+				t.Errorf("describeJSONError = %q, want containing %q", got, tt.want)
+			}
+		})
+	}
+
+	var r SniffRule
+	err := json.Unmarshal([]byte(`{"name": 1}`), &r)
+	if got := describeJSONError(nil, err); !strings.Contains(got, "字段 name") || strings.Contains(got, "行") {
+		t.Errorf("field error = %q", got)
+	}
+}
+
+func TestLineCol(t *testing.T) {
+	data := []byte("ab\ncd\n")
+	tests := []struct {
+		offset    int64
+		line, col int
+	}{
+		{0, 1, 1},
+		{2, 1, 3},
+		{3, 2, 1},
+		{5, 2, 3},
+		{100, 3, 1},
+	}
+	for _, tt := range tests {
+		if line, col := lineCol(data, tt.offset); line != tt.line || col != tt.col {
+			t.Errorf("lineCol(%d) = %d:%d, want %d:%d", tt.offset, line, col, tt.line, tt.col)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	raw := `[
+		{"name": "low", "host_keyword": "a"},
+		{"name": "bad-regex", "url_regex": "("},
+		{"name": "high", "host_keyword": "b", "priority": 10},
+		{"name": "low", "host_keyword": "dup"},
+		{"name": "typo", "host_keyword": "c", "hostkeyword": "x"},
+		{"name": "catch-all"},
+		{"name": 5}
+	]`
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		t.Fatal(err)
+	}
+	rules, warnings := parseRules(entries)
+
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	if got := strings.Join(names, ","); got != "high,low,typo,catch-all" {
+		t.Errorf("rules = %s", got)
+	}
+	if rules[1].HostKeyword != "a" {
+		t.Error("duplicate name replaced the first rule")
+	}
+
+	wantWarnings := []string{
+		"第 2 条规则已跳过: 规则 bad-regex: url_regex 无效",
+		"第 4 条规则已跳过: 名称 low 与前面的规则重复",
+		"第 5 条规则 (typo): 未知字段 \"hostkeyword\"",
+		"第 6 条规则 (catch-all): 规则 catch-all: 至少需要设置",
+		"第 7 条规则已跳过: 字段 name",
+	}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("warnings = %q", warnings)
+	}
+	for i, want := range wantWarnings {
+		if !strings.HasPrefix(warnings[i], want) {
+			t.Errorf("warning %d = %q, want prefix %q", i, warnings[i], want)
+		}
+	}
+}
+
+func TestSnifferReloadRules(t *testing.T) {
+	s := newRulesTestSniffer(t)
+
+	// 文件不存在时没有规则也没有错误
+	if err := s.ReloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	if set := s.ListRules(); len(set.Rules) != 0 || set.Error != "" {
+		t.Errorf("empty rule set = %+v", set)
+	}
+
+	writeRulesFile(t, s, `[{"name": "a", "host_keyword": "cdn"}]`)
+	if err := s.ReloadRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 格式错误时保留之前的规则
+	writeRulesFile(t, s, `[{"name": "a", "host_keyword": "cdn"`)
+	if err := s.ReloadRules(); err == nil {
+		t.Fatal("expected error for malformed file")
+	}
+	set := s.ListRules()
+	if len(set.Rules) != 1 || set.Rules[0].Name != "a" || set.Error == "" {
+		t.Errorf("rule set after failed reload = %+v", set)
+	}
+
+	// 格式错误时拒绝修改文件
+	if err := s.SaveRule(SniffRule{Name: "b", HostKeyword: "x"}, ""); err == nil || !strings.Contains(err.Error(), "请先修正规则文件") {
+		t.Errorf("SaveRule on malformed file: %v", err)
+	}
+}
+
+func TestSnifferSaveAndDeleteRule(t *testing.T) {
+	s := newRulesTestSniffer(t)
+	// 手工编辑的无效规则与未知字段在保存其他规则后原样保留
+	writeRulesFile(t, s, `[{"name": "manual", "host_keyword": "m", "comment": "keep me"}, {"name": "broken", "url_regex": "("}]`)
+	if err := s.ReloadRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		run      func() error
+		wantErr  string
+		wantList string
+	}{
+		{"新增", func() error { return s.SaveRule(SniffRule{Name: " site ", HostKeyword: "cdn", Priority: 1}, "") }, "", "site,manual"},
+		{"重名", func() error { return s.SaveRule(SniffRule{Name: "manual", HostKeyword: "x"}, "") }, "已存在", "site,manual"},
+		{"校验失败", func() error { return s.SaveRule(SniffRule{Name: "empty"}, "") }, "至少需要设置", "site,manual"},
+		{"改名", func() error { return s.SaveRule(SniffRule{Name: "site2", HostKeyword: "cdn", Priority: 1}, "site") }, "", "site2,manual"},
+		{"改成已有名称", func() error { return s.SaveRule(SniffRule{Name: "manual", HostKeyword: "cdn"}, "site2") }, "已存在", "site2,manual"},
+		{"原规则不存在", func() error { return s.SaveRule(SniffRule{Name: "x", HostKeyword: "cdn"}, "gone") }, "不存在", "site2,manual"},
+		{"删除", func() error { return s.DeleteRule("site2") }, "", "manual"},
+		{"删除不存在的规则", func() error { return s.DeleteRule("site2") }, "不存在", "manual"},
+	}
+	for _, st := range steps {
+		err := st.run()
+		if st.wantErr == "" && err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if st.wantErr != "" && (err == nil || !strings.Contains(err.Error(), st.wantErr)) {
+			t.Fatalf("%s: err = %v, want containing %q", st.name, err, st.wantErr)
+		}
+		var names []string
+		for _, r := range s.ListRules().Rules {
+			names = append(names, r.Name)
+		}
+		if got := strings.Join(names, ","); got != st.wantList {
+			t.Fatalf("%s: rules = %s, want %s", st.name, got, st.wantList)
+		}
+	}
+
+	data, err := os.ReadFile(s.rulesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"keep me"`) || !strings.Contains(string(data), `"broken"`) {
+		t.Errorf("hand-written entries lost:\n%s", data)
+	}
+}
+
+func TestSnifferTestRule(t *testing.T) {
+	s := newRulesTestSniffer(t)
+	writeRulesFile(t, s, `[
+		{"name": "ads", "host_keyword": "ads.", "exclude": true, "priority": 10},
+		{"name": "site", "host_keyword": "cdn.example", "capture_headers": ["Referer"],
+		 "inject_headers": {"Origin": "https://example.com"}, "type": "hls",
+		 "rewrites": [{"match": "_480p", "replace": "_1080p", "remove_params": ["start"]}]}
+	]`)
+	if err := s.ReloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{"referer": "https://example.com/watch", "cookie": "sid=1"}
+
+	res := s.TestRule(RuleTestRequest{Url: "https://cdn.example.com/v_480p.mp4?start=5", Headers: headers})
+	if !res.Matched || res.RuleName != "site" || res.Excluded {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.RewrittenUrl != "https://cdn.example.com/v_1080p.mp4" || res.Type != "hls" {
+		t.Errorf("rewrite/type = %s %s", res.RewrittenUrl, res.Type)
+	}
+	if len(res.Headers) != 2 || res.Headers["Referer"] != "https://example.com/watch" || res.Headers["Origin"] != "https://example.com" {
+		t.Errorf("headers = %v", res.Headers)
+	}
+
+	res = s.TestRule(RuleTestRequest{Url: "https://ads.example.com/v.mp4", Headers: headers})
+	if !res.Excluded || res.RuleName != "ads" || len(res.Headers) != 0 {
+		t.Errorf("exclude result = %+v", res)
+	}
+
+	res = s.TestRule(RuleTestRequest{Url: "https://other.example.com/v.mp4", Headers: headers})
+	if res.Matched || res.Headers["Cookie"] != "sid=1" || res.Type != "mp4" {
+		t.Errorf("unmatched result = %+v", res)
+	}
+
+	// 测试尚未保存的规则，不使用当前规则
+	draft := &SniffRule{Name: "draft", HostKeyword: "other.example"}
+	if res = s.TestRule(RuleTestRequest{Url: "https://other.example.com/v.mp4", Rule: draft}); !res.Matched || res.RuleName != "draft" {
+		t.Errorf("draft result = %+v", res)
+	}
+	if res = s.TestRule(RuleTestRequest{Url: "https://cdn.example.com/v.mp4", Rule: draft}); res.Matched {
+		t.Errorf("draft matched unrelated URL: %+v", res)
+	}
+	if res = s.TestRule(RuleTestRequest{Url: "https://cdn.example.com/v.mp4", Rule: &SniffRule{Name: "x"}}); res.Error == "" {
+		t.Error("invalid draft accepted")
+	}
+	if res = s.TestRule(RuleTestRequest{Url: " "}); res.Error == "" {
+		t.Error("empty URL accepted")
+	}
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func compiledRule(t *testing.T, r SniffRule) *SniffRule {
+	t.Helper()
+	if r.Name == "" {
+		r.Name = "test"
+	}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return &r
+}
+
+func TestSniffRuleCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    SniffRule
+		wantErr string
+	}{
+		{"最简规则", SniffRule{Name: "a"}, ""},
+		{"名称为空", SniffRule{Name: "  "}, "名称不能为空"},
+		{"url_regex 无效", SniffRule{Name: "a", UrlRegex: "("}, "url_regex"},
+		{"排除规则无效", SniffRule{Name: "a", ExcludePatterns: []string{"["}}, "排除规则无效"},
+		{"改写为空", SniffRule{Name: "a", Rewrites: []URLRewrite{{}}}, "第 1 个改写"},
+		{"改写 match 无效", SniffRule{Name: "a", Rewrites: []URLRewrite{{RemoveParams: []string{"x"}}, {Match: "("}}}, "第 2 个改写的 match"},
+		{"未知类型", SniffRule{Name: "a", Type: "dash"}, "未知的类型"},
+		{"请求头名含冒号", SniffRule{Name: "a", InjectHeaders: map[string]string{"X:Y": "1"}}, "无效的请求头名"},
+		{"请求头名为空", SniffRule{Name: "a", InjectHeaders: map[string]string{" ": "1"}}, "无效的请求头名"},
+		{"输出目录越界", SniffRule{Name: "a", OutputDir: "../outside"}, "下载目录之外"},
+		{"输出目录子目录", SniffRule{Name: "a", OutputDir: "site/../site/videos"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.compile()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSniffRuleMatches(t *testing.T) {
+	r := compiledRule(t, SniffRule{HostKeyword: "cdn.example", MustContain: ".mp4", TargetReferer: "watch", UrlRegex: `/v/\d+`})
+	tests := []struct {
+		url, doc string
+		want     bool
+	}{
+		{"https://cdn.example.com/v/123.mp4", "https://example.com/watch?id=1", true},
+		{"https://img.example.com/v/123.mp4", "https://example.com/watch?id=1", false},
+		{"https://cdn.example.com/v/123.m3u8", "https://example.com/watch?id=1", false},
+		{"https://cdn.example.com/v/123.mp4", "https://example.com/home", false},
+		{"https://cdn.example.com/v/abc.mp4", "https://example.com/watch?id=1", false},
+	}
+	for _, tt := range tests {
+		if got := r.matches(tt.url, tt.doc); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.url, tt.doc, got, tt.want)
+		}
+	}
+	if !compiledRule(t, SniffRule{}).matches("anything", "") {
+		t.Error("rule without conditions should match everything")
+	}
+}
+
+func TestSniffRuleRewriteURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []URLRewrite
+		in, want string
+	}{
+		{"无改写", nil, "https://cdn.example.com/v.mp4?a=1", "https://cdn.example.com/v.mp4?a=1"},
+		{"正则替换", []URLRewrite{{Match: `_480p`, Replace: `_1080p`}}, "https://cdn.example.com/v_480p.mp4", "https://cdn.example.com/v_1080p.mp4"},
+		{"分组引用", []URLRewrite{{Match: `/(\d+)/low\.mp4`, Replace: `/${1}/high.mp4`}}, "https://cdn.example.com/42/low.mp4", "https://cdn.example.com/42/high.mp4"},
+		{"删除参数", []URLRewrite{{RemoveParams: []string{"start", "end"}}}, "https://cdn.example.com/v.mp4?start=10&id=1&end=20", "https://cdn.example.com/v.mp4?id=1"},
+		{
+			"依次应用",
+			[]URLRewrite{{Match: `_480p`, Replace: `_720p`}, {Match: `_720p`, Replace: `_1080p`, RemoveParams: []string{"start"}}},
+			"https://cdn.example.com/v_480p.mp4?start=5",
+			"https://cdn.example.com/v_1080p.mp4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := compiledRule(t, SniffRule{Rewrites: tt.rewrites})
+			if got := r.RewriteURL(tt.in); got != tt.want {
+				t.Errorf("RewriteURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveQueryParams(t *testing.T) {
+	tests := []struct {
+		in    string
+		names []string
+		want  string
+	}{
+		// 其余参数保持原始顺序与编码
+		{"https://a.com/v?z=1&start=2&a=%2F", []string{"start"}, "https://a.com/v?z=1&a=%2F"},
+		{"https://a.com/v?start=1&start=2", []string{"start"}, "https://a.com/v"},
+		{"https://a.com/v?st%61rt=1&b", []string{"start"}, "https://a.com/v?b"},
+		{"https://a.com/v?Start=1", []string{"start"}, "https://a.com/v?Start=1"},
+		{"https://a.com/v", []string{"start"}, "https://a.com/v"},
+		{"https://a.com/v?x=1#frag", []string{"x"}, "https://a.com/v#frag"},
+	}
+	for _, tt := range tests {
+		if got := removeQueryParams(tt.in, tt.names); got != tt.want {
+			t.Errorf("removeQueryParams(%q, %v) = %q, want %q", tt.in, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestSortRules(t *testing.T) {
+	rules := []SniffRule{
+		{Name: "a"},
+		{Name: "b", Priority: 10},
+		{Name: "c"},
+		{Name: "d", Priority: 10},
+		{Name: "e", Priority: -1},
+	}
+	sortRules(rules)
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Name)
+	}
+	// 相同优先级保持文件中的顺序
+	if strings.Join(got, ",") != "b,d,a,c,e" {
+		t.Errorf("order = %v", got)
+	}
+}
+
+func TestSnifferMatchRulePriority(t *testing.T) {
+	rules := []SniffRule{
+		*compiledRule(t, SniffRule{Name: "generic", MustContain: ".mp4"}),
+		*compiledRule(t, SniffRule{Name: "block-ads", HostKeyword: "ads.", Exclude: true, Priority: 5}),
+	}
+	sortRules(rules)
+	s := &Sniffer{rules: rules}
+	if r := s.matchRule("https://ads.example.com/v.mp4", ""); r == nil || r.Name != "block-ads" {
+		t.Errorf("higher priority exclude rule not matched first: %+v", r)
+	}
+	if r := s.matchRule("https://cdn.example.com/v.mp4", ""); r == nil || r.Name != "generic" {
+		t.Errorf("generic rule not matched: %+v", r)
+	}
+	if r := s.matchRule("https://cdn.example.com/a.m3u8", ""); r != nil {
+		t.Errorf("unexpected match: %s", r.Name)
+	}
+
+	// GetRule 返回副本
+	got := s.GetRule("generic")
+	got.MustContain = ".flv"
+	if s.rules[1].MustContain != ".mp4" {
+		t.Error("GetRule returned shared rule")
+	}
+}
+
+func TestFilterHeaders(t *testing.T) {
+	s := &Sniffer{}
+	cdp := network.Headers{"referer": "https://example.com/", "cookie": "sid=1", "user-agent": "UA", "x-token": "t"}
+
+	got := s.filterHeaders(cdp, nil)
+	if len(got) != 3 || got["Referer"] != "https://example.com/" || got["Cookie"] != "sid=1" || got["User-Agent"] != "UA" {
+		t.Errorf("default headers = %v", got)
+	}
+
+	rule := compiledRule(t, SniffRule{
+		CaptureHeaders: []string{"X-Token", "Cookie"},
+		InjectHeaders:  map[string]string{"cookie": "injected=1", "Origin": "https://example.com"},
+	})
+	got = s.filterHeaders(cdp, rule)
+	want := map[string]string{"X-Token": "t", "cookie": "injected=1", "Origin": "https://example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("rule headers = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("rule headers = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSniffRuleInjectsHeader(t *testing.T) {
+	var nilRule *SniffRule
+	if nilRule.InjectsHeader("Cookie") {
+		t.Error("nil rule injects header")
+	}
+	r := &SniffRule{InjectHeaders: map[string]string{"cookie": "a=1"}}
+	if !r.InjectsHeader("Cookie") || r.InjectsHeader("Referer") {
+		t.Error("InjectsHeader mismatch")
+	}
+}
+
+func TestSniffRuleResolveOutputDir(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "downloads")
+	abs := filepath.Join(t.TempDir(), "elsewhere")
+	tests := []struct {
+		rule *SniffRule
+		want string
+	}{
+		{nil, base},
+		{&SniffRule{}, base},
+		{&SniffRule{OutputDir: "site"}, filepath.Join(base, "site")},
+		{&SniffRule{OutputDir: abs}, abs},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.ResolveOutputDir(base); got != tt.want {
+			t.Errorf("ResolveOutputDir = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSniffRuleClone(t *testing.T) {
+	size := 10
+	r := compiledRule(t, SniffRule{
+		CaptureHeaders: []string{"Referer"},
+		InjectHeaders:  map[string]string{"Origin": "a"},
+		Rewrites:       []URLRewrite{{RemoveParams: []string{"start"}}},
+		MinSizeKB:      &size,
+	})
+	c := r.clone()
+	c.CaptureHeaders[0] = "Cookie"
+	c.InjectHeaders["Origin"] = "b"
+	c.Rewrites[0].RemoveParams[0] = "end"
+	*c.MinSizeKB = 20
+	if r.CaptureHeaders[0] != "Referer" || r.InjectHeaders["Origin"] != "a" || r.Rewrites[0].RemoveParams[0] != "start" || *r.MinSizeKB != 10 {
+		t.Errorf("clone shares state with original: %+v", r)
+	}
+}
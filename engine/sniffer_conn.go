@@ -0,0 +1,585 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// 嗅探连接状态，通过 sniffer_state 事件通知前端
+const (
+	SnifferDisconnected = "disconnected"
+	SnifferConnecting   = "connecting"
+	SnifferConnected    = "connected"
+	SnifferReconnecting = "reconnecting"
+)
+
+const (
+	reconnectMaxAttempts = 10
+	reconnectMaxDelay    = 30 * time.Second
+	browserCloseTimeout  = 5 * time.Second
+	detachTimeout        = 2 * time.Second
+)
+
+// SnifferState 嗅探器的连接状态
+type SnifferState struct {
+	State    string `json:"state"`
+	Endpoint string `json:"endpoint"` // host:port
+	Attached bool   `json:"attached"` // 附加到的是外部浏览器
+	Tabs     int    `json:"tabs"`     // 正在嗅探的标签页数
+	Message  string `json:"message"`  // 断开原因、重连进度等
+}
+
+// tabSession 单个标签页上的嗅探会话
+// chromedp 取消标签页上下文时会对该标签页执行 CloseTarget，因此会话分两层：
+// tabCtx 持有 chromedp 的标签页上下文，不随连接取消，只在 WebSocket 断开后才释放（见 cdpConn.close），
+// 此时关闭命令已无法发出；ctx 派生自 tabCtx，所有 CDP 调用与事件监听都使用它，断开会话时立即取消
+type tabSession struct {
+	targetID  string
+	browser   *chromedp.Browser
+	tabCtx    context.Context
+	tabCancel context.CancelFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu        sync.Mutex // 保护 sessionID 与 detached，首次附加期间与 detach 互斥
+	sessionID target.SessionID
+	detached  bool
+}
+
+// run 在标签页上执行 CDP 动作，会话已断开时直接返回
+// 首次执行时 chromedp 才附加到标签页，记下会话 ID 供 detach 使用
+func (t *tabSession) run(actions ...chromedp.Action) error {
+	t.mu.Lock()
+	if t.detached {
+		t.mu.Unlock()
+		return context.Canceled
+	}
+	if t.sessionID != "" {
+		t.mu.Unlock()
+		return chromedp.Run(t.ctx, actions...)
+	}
+	defer t.mu.Unlock()
+	err := chromedp.Run(t.ctx, actions...)
+	if c := chromedp.FromContext(t.ctx); c != nil && c.Target != nil {
+		t.sessionID = c.Target.SessionID
+	}
+	return err
+}
+
+// detach 通过 Target.detachFromTarget 解除调试会话，标签页保持打开
+// 进行中的 CDP 调用会被取消；标签页已关闭时解除会失败，可以忽略
+func (t *tabSession) detach() {
+	t.mu.Lock()
+	if t.detached {
+		t.mu.Unlock()
+		return
+	}
+	t.detached = true
+	t.mu.Unlock()
+	t.cancel()
+
+	// 等待可能正在进行的首次附加返回，之后会话 ID 不再变化
+	t.mu.Lock()
+	id := t.sessionID
+	t.mu.Unlock()
+	if id == "" || t.browser == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), detachTimeout)
+	defer cancel()
+	_ = target.DetachFromTarget().WithSessionID(id).Do(cdp.WithExecutor(ctx, t.browser))
+}
+
+// cdpConn 一次到浏览器的 CDP 连接
+// browserCtx 只连接浏览器本身、不绑定任何标签页，各标签页的上下文都派生自它，
+// 关闭某个标签页不会影响其他标签页
+type cdpConn struct {
+	hostPort      string
+	browserCtx    context.Context
+	browserCancel context.CancelFunc
+	allocCancel   context.CancelFunc // 关闭 WebSocket
+	browser       *chromedp.Browser  // 连接建立后才有值
+
+	mu      sync.Mutex
+	tabs    map[string]*tabSession // key 为 TargetID
+	retired []*tabSession          // 已断开但标签页仍打开的会话，连接关闭后再释放
+	done    bool
+}
+
+// addTab 为页面创建会话，已存在或连接已关闭时返回 nil
+func (c *cdpConn) addTab(targetID string) *tabSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done || c.tabs[targetID] != nil {
+		return nil
+	}
+	// 标签页上下文不随 browserCtx 取消，否则连接关闭时 chromedp 会关闭所有标签页
+	tabCtx, tabCancel := chromedp.NewContext(context.WithoutCancel(c.browserCtx), chromedp.WithTargetID(target.ID(targetID)))
+	ctx, cancel := context.WithCancel(tabCtx)
+	sess := &tabSession{
+		targetID:  targetID,
+		browser:   c.browser,
+		tabCtx:    tabCtx,
+		tabCancel: tabCancel,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	c.tabs[targetID] = sess
+	return sess
+}
+
+// removeTab 断开标签页的会话
+// closed 为 true 表示标签页已关闭，可以立即释放上下文；否则标签页仍打开，上下文留到连接关闭时释放
+func (c *cdpConn) removeTab(targetID string, closed bool) {
+	c.mu.Lock()
+	sess := c.tabs[targetID]
+	delete(c.tabs, targetID)
+	if sess != nil && !closed && !c.done {
+		c.retired = append(c.retired, sess)
+	}
+	c.mu.Unlock()
+	if sess == nil {
+		return
+	}
+	sess.detach()
+	if closed {
+		go sess.tabCancel()
+	}
+}
+
+func (c *cdpConn) tabCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tabs)
+}
+
+// close 断开所有标签页并关闭连接，不关闭标签页本身
+// 先逐个解除会话，再断开 WebSocket，最后才释放 chromedp 的标签页上下文
+func (c *cdpConn) close() {
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		return
+	}
+	c.done = true
+	sessions := c.retired
+	for _, sess := range c.tabs {
+		sessions = append(sessions, sess)
+	}
+	c.tabs, c.retired = nil, nil
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sess := range sessions {
+		wg.Add(1)
+		go func(sess *tabSession) {
+			defer wg.Done()
+			sess.detach()
+		}(sess)
+	}
+	wg.Wait()
+
+	c.browserCancel()
+	c.allocCancel()
+	if c.browser != nil {
+		select {
+		case <-c.browser.LostConnection:
+		case <-time.After(detachTimeout):
+		}
+	}
+	// chromedp 释放标签页上下文时最多等待 1 秒的关闭命令，放到后台执行
+	for _, sess := range sessions {
+		go sess.tabCancel()
+	}
+}
+
+// devToolsTarget /json/list 返回的调试目标
+type devToolsTarget struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// parseDevToolsEndpoint 解析用户输入的调试地址
+// 支持 host:port、http://host:port 与 ws://host:port/devtools/browser/<id>，
+// 返回用于 HTTP 查询的 host:port 以及交给 chromedp 的 WebSocket 地址
+func parseDevToolsEndpoint(endpoint string) (hostPort, wsURL string, err error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return "", "", fmt.Errorf("调试地址不能为空")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("调试地址无效: %v", err)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return "", "", fmt.Errorf("调试地址缺少端口: %s", endpoint)
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		if strings.Contains(u.Path, "/devtools/browser/") {
+			return u.Host, u.String(), nil
+		}
+		return u.Host, u.Scheme + "://" + u.Host + "/", nil
+	case "http", "https":
+		// chromedp 会通过 /json/version 查询真正的 WebSocket 地址
+		return u.Host, "ws://" + u.Host + "/", nil
+	}
+	return "", "", fmt.Errorf("不支持的调试地址协议: %s", u.Scheme)
+}
+
+// listPageTargets 通过 /json/list 列出所有页面（不含扩展、Service Worker 等）
+func listPageTargets(hostPort string) ([]devToolsTarget, error) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/json/list", hostPort))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var targets []devToolsTarget
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return nil, err
+	}
+	pages := make([]devToolsTarget, 0, len(targets))
+	for _, t := range targets {
+		if t.Type == "page" && t.ID != "" {
+			pages = append(pages, t)
+		}
+	}
+	return pages, nil
+}
+
+// listenToCDP 等待自己启动的浏览器调试接口就绪后连接
+func (s *Sniffer) listenToCDP(port int, stop chan struct{}) {
+	hostPort := fmt.Sprintf("127.0.0.1:%d", port)
+
+	// 等待浏览器调试端口完全就绪，防止 i/o timeout
+	log.Printf("等待浏览器调试接口就绪...")
+	for i := 0; i < 20; i++ { // 最多等待 10 秒
+		if pages, err := listPageTargets(hostPort); err == nil && len(pages) > 0 {
+			if err := s.connect(hostPort, fmt.Sprintf("ws://%s/", hostPort), stop); err != nil {
+				log.Printf("无法初始化 CDP 连接: %v", err)
+				s.finish(stop, err.Error())
+			}
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	log.Printf("错误: 浏览器调试接口未就绪")
+	s.finish(stop, "浏览器调试接口未就绪")
+}
+
+// Attach 附加到一个已开启远程调试的浏览器（保留其登录状态），不会启动新的浏览器
+// endpoint 可以是 host:port、http://host:port 或 ws://host:port/devtools/browser/<id>
+func (s *Sniffer) Attach(endpoint string) error {
+	hostPort, wsURL, err := parseDevToolsEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	stop, err := s.begin(hostPort, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := listPageTargets(hostPort); err != nil {
+		err = fmt.Errorf("无法访问调试接口 %s: %v", hostPort, err)
+		s.finish(stop, err.Error())
+		return err
+	}
+	if err := s.connect(hostPort, wsURL, stop); err != nil {
+		s.finish(stop, err.Error())
+		return err
+	}
+	return nil
+}
+
+// begin 开始一轮嗅探会话，返回本轮的停止信号；已在运行时返回错误
+func (s *Sniffer) begin(hostPort string, attached bool) (chan struct{}, error) {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("已连接到浏览器，请先断开")
+	}
+	s.stop = make(chan struct{})
+	s.endpoint = hostPort
+	s.attached = attached
+	stop := s.stop
+	s.mu.Unlock()
+
+	s.setState(SnifferConnecting, "")
+	return stop, nil
+}
+
+// connect 建立 CDP 连接：附加到所有已打开的页面，并监听之后新开或关闭的标签页
+func (s *Sniffer) connect(hostPort, wsURL string, stop chan struct{}) error {
+	allocCtx, allocCancel := chromedp.NewRemoteAllocator(context.Background(), wsURL)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	conn := &cdpConn{
+		hostPort:      hostPort,
+		browserCtx:    browserCtx,
+		browserCancel: browserCancel,
+		allocCancel:   allocCancel,
+		tabs:          make(map[string]*tabSession),
+	}
+
+	// Targets 只连接浏览器而不会新建标签页
+	infos, err := chromedp.Targets(browserCtx)
+	if err == nil {
+		conn.browser = chromedp.FromContext(browserCtx).Browser
+		chromedp.ListenBrowser(browserCtx, s.browserEventHandler(conn))
+		err = target.SetDiscoverTargets(true).Do(cdp.WithExecutor(browserCtx, conn.browser))
+	}
+	if err != nil {
+		conn.close()
+		return fmt.Errorf("无法建立 CDP 连接: %v", err)
+	}
+
+	s.mu.Lock()
+	if s.stop != stop {
+		// 连接期间已被 Stop
+		s.mu.Unlock()
+		conn.close()
+		return fmt.Errorf("嗅探已停止")
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	pages := 0
+	for _, info := range infos {
+		if info.Type == "page" {
+			pages++
+			go s.attachTab(conn, string(info.TargetID))
+		}
+	}
+	log.Printf("CDP 连接已建立 (%s)，共 %d 个页面", hostPort, pages)
+	s.setState(SnifferConnected, "")
+
+	go s.watchConnection(conn, stop)
+	return nil
+}
+
+// browserEventHandler 处理浏览器级别的标签页生命周期事件
+// 回调在 chromedp 的事件循环中执行，不能阻塞，CDP 调用都放到协程中
+func (s *Sniffer) browserEventHandler(conn *cdpConn) func(ev interface{}) {
+	return func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *target.EventTargetCreated:
+			// 过滤 about:blank，等导航到真实地址（TargetInfoChanged）后再附加
+			if ev.TargetInfo.Type == "page" && ev.TargetInfo.URL != "about:blank" {
+				log.Printf("检测到新标签页: %s", ev.TargetInfo.TargetID)
+				go func(id string) {
+					// 给浏览器一点点反应时间
+					time.Sleep(200 * time.Millisecond)
+					s.attachTab(conn, id)
+				}(string(ev.TargetInfo.TargetID))
+			}
+		case *target.EventTargetInfoChanged:
+			if ev.TargetInfo.Type != "page" {
+				return
+			}
+			if ev.TargetInfo.URL != "about:blank" {
+				go s.attachTab(conn, string(ev.TargetInfo.TargetID))
+			}
+			if ev.TargetInfo.Attached {
+				s.manager.emitEvent("tab_focused", ev.TargetInfo.TargetID)
+			}
+		case *target.EventTargetDestroyed:
+			conn.removeTab(string(ev.TargetID), true)
+			s.manager.emitEvent("tab_closed", ev.TargetID)
+			go s.refreshState()
+		}
+	}
+}
+
+// attachTab 附加到一个页面并开始嗅探，已附加的页面忽略
+func (s *Sniffer) attachTab(conn *cdpConn, targetID string) {
+	sess := conn.addTab(targetID)
+	if sess == nil {
+		return
+	}
+	if err := s.attachSnifferToContext(sess); err != nil {
+		conn.removeTab(targetID, false)
+		return
+	}
+	s.refreshState()
+}
+
+// watchConnection 连接断开（浏览器退出、崩溃或重启）后按指数退避重连，直到成功或 Stop
+func (s *Sniffer) watchConnection(conn *cdpConn, stop chan struct{}) {
+	select {
+	case <-stop:
+		return
+	case <-conn.browserCtx.Done():
+	}
+	conn.close()
+
+	s.mu.Lock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+	attached, exited := s.attached, s.browserExited
+	s.mu.Unlock()
+	log.Printf("与浏览器的连接已断开 (%s)", conn.hostPort)
+
+	// 用 host:port 重新查询 WebSocket 地址，浏览器重启后 /devtools/browser/<id> 会变化
+	wsURL := fmt.Sprintf("ws://%s/", conn.hostPort)
+	delay := time.Second
+	for attempt := 1; attempt <= reconnectMaxAttempts; attempt++ {
+		if !attached && isClosed(exited) {
+			s.finish(stop, "浏览器已关闭")
+			return
+		}
+		s.setState(SnifferReconnecting, fmt.Sprintf("第 %d/%d 次重连，%v 后重试", attempt, reconnectMaxAttempts, delay))
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		if err := s.connect(conn.hostPort, wsURL, stop); err == nil {
+			return
+		} else {
+			log.Printf("重连失败: %v", err)
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+	s.finish(stop, "多次重连失败，已停止嗅探")
+}
+
+// finish 本轮会话异常结束（不再重连），释放停止信号以便重新启动或附加
+func (s *Sniffer) finish(stop chan struct{}, reason string) {
+	s.mu.Lock()
+	if s.stop == stop {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Unlock()
+	s.setState(SnifferDisconnected, reason)
+}
+
+// Detach 断开与浏览器的调试连接，不关闭任何标签页，也不关闭浏览器
+func (s *Sniffer) Detach() {
+	s.Stop(false)
+}
+
+// Stop 停止嗅探并释放所有上下文
+// closeBrowser 为 true 且浏览器是由本程序启动的时，同时关闭浏览器进程；附加的外部浏览器始终保持打开
+func (s *Sniffer) Stop(closeBrowser bool) {
+	s.mu.Lock()
+	stop, conn := s.stop, s.conn
+	s.stop, s.conn = nil, nil
+	cmd, exited, attached := s.browserCmd, s.browserExited, s.attached
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+	if closeBrowser && !attached && cmd != nil {
+		s.closeBrowser(conn, cmd, exited)
+	}
+	if conn != nil {
+		conn.close()
+	}
+	if stop != nil || conn != nil {
+		log.Printf("已停止嗅探")
+		s.setState(SnifferDisconnected, "")
+	}
+}
+
+// closeBrowser 先通过 CDP 请求浏览器正常退出（保存会话、释放配置目录锁），超时后强制结束进程
+func (s *Sniffer) closeBrowser(conn *cdpConn, cmd *exec.Cmd, exited chan struct{}) {
+	if isClosed(exited) {
+		return
+	}
+	if conn != nil {
+		ctx, cancel := context.WithTimeout(conn.browserCtx, 2*time.Second)
+		if c := chromedp.FromContext(conn.browserCtx); c != nil && c.Browser != nil {
+			_ = browser.Close().Do(cdp.WithExecutor(ctx, c.Browser))
+		}
+		cancel()
+	}
+	select {
+	case <-exited:
+	case <-time.After(browserCloseTimeout):
+		log.Printf("浏览器未在 %v 内退出，强制结束", browserCloseTimeout)
+		_ = cmd.Process.Kill()
+	}
+}
+
+// IsConnected 是否已连接到浏览器
+func (s *Sniffer) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// GetState 返回当前连接状态
+func (s *Sniffer) GetState() SnifferState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state
+	if s.conn != nil {
+		st.Tabs = s.conn.tabCount()
+	}
+	return st
+}
+
+// setState 更新连接状态并通知前端
+func (s *Sniffer) setState(state, message string) {
+	s.mu.Lock()
+	s.state = SnifferState{
+		State:    state,
+		Endpoint: s.endpoint,
+		Attached: s.attached,
+		Message:  message,
+	}
+	if s.conn != nil {
+		s.state.Tabs = s.conn.tabCount()
+	}
+	st := s.state
+	s.mu.Unlock()
+	s.manager.emitEvent("sniffer_state", st)
+}
+
+// refreshState 标签页增减后重新推送状态（更新标签页数）
+func (s *Sniffer) refreshState() {
+	st := s.GetState()
+	if st.State == SnifferConnected {
+		s.manager.emitEvent("sniffer_state", st)
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	if ch == nil {
+		return false
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
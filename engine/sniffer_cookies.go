@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrSnifferNotConnected 未连接浏览器，无法读取 Cookie
+var ErrSnifferNotConnected = errors.New("未连接到浏览器")
+
+// cookieTimeout 读取 Cookie 的超时，浏览器无响应时不阻塞下载
+const cookieTimeout = 5 * time.Second
+
+// CookieProvider 在下载开始前提供最新的 Cookie
+type CookieProvider interface {
+	// CookieHeader 返回浏览器中对这些地址生效的 Cookie（Cookie 请求头格式），没有时返回空
+	// targetID 为任务来源的标签页，已关闭时使用任意已附加的标签页
+	CookieHeader(targetID string, urls ...string) (string, error)
+}
+
+// CookieHeader 通过 Network.getCookies 读取浏览器 Cookie
+// getCookies 按域名、路径、Secure 等规则筛选出对给定地址生效的 Cookie，结果与浏览器实际发送的一致
+func (s *Sniffer) CookieHeader(targetID string, urls ...string) (string, error) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return "", ErrSnifferNotConnected
+	}
+	sess := conn.session(targetID)
+	if sess == nil {
+		return "", ErrSnifferNotConnected
+	}
+
+	var cookies []*network.Cookie
+	err := sess.run(chromedp.ActionFunc(func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, cookieTimeout)
+		defer cancel()
+		var err error
+		cookies, err = network.GetCookies().WithURLs(urls).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return "", err
+	}
+
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; "), nil
+}
+
+// session 返回指定标签页的会话，不存在时返回任意一个，没有已附加的标签页返回 nil
+func (c *cdpConn) session(targetID string) *tabSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sess := c.tabs[targetID]; sess != nil {
+		return sess
+	}
+	for _, sess := range c.tabs {
+		return sess
+	}
+	return nil
+}
@@ -0,0 +1,519 @@
+package engine
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// maxBodyFetch 无法流式读取时，通过 getResponseBody 读取完整响应的大小上限
+const maxBodyFetch = 8 << 20
+
+// maxStreamSniff 通过 CDP 流式读取文件头的响应大小上限
+// 流式读取一旦开启，整个响应都会以 base64 经 dataReceived 传回且无法中途停止；
+// 更大或大小未知的响应只对 URL 像媒体的 GET 请求单独发 Range 请求读取开头，
+// 其余等加载完成后在 maxBodyFetch 以内读取完整响应，否则按 URL 判断
+const maxStreamSniff = 1 << 20
+
+// sniffCandidate 等待响应确认的候选请求
+// Request 与 Response 异步成对出现，先暂存请求信息，收到响应后再决定是否上报
+type sniffCandidate struct {
+	event    *SniffEvent
+	rule     *SniffRule      // 命中的规则（副本），可为空
+	headers  network.Headers // 原始请求头，与 extraInfo 合并后重新过滤
+	method   string          // 请求方法，只有 GET 请求可以由程序单独再请求一次
+	urlMatch bool            // URL 本身像媒体或命中了规则，文件头无法识别时按 URL 判断
+
+	sniffing  bool   // 已开始读取响应开头的字节
+	streaming bool   // 流式读取已开启，dataReceived 的数据直接追加到 head
+	fallback  bool   // 流式读取不可用，加载完成后改用 getResponseBody
+	probing   bool   // 正在通过单独的 Range 请求读取开头（仅限 URL 像媒体的 GET 请求）
+	finished  bool   // 响应已加载完成
+	playlist  bool   // 已确认是 m3u8，需要读取完整内容预解析
+	head      []byte // 已读到的响应开头
+	streamed  []byte // streamResourceContent 之后 dataReceived 带来的数据
+}
+
+// tabSniffer 单个标签页的请求监听
+type tabSniffer struct {
+	s    *Sniffer
+	sess *tabSession
+
+	mu      sync.Mutex // 保护 pending、extra 与 dedupe，CDP 回调与读取响应的协程都会访问
+	pending map[network.RequestID]*sniffCandidate
+	extra   map[network.RequestID]network.Headers // 先于 requestWillBeSent 到达的 extraInfo
+	dedupe  *sniffDeduper
+
+	suppressed int64 // 本标签页被过滤的嗅探结果数
+}
+
+// attachSnifferToContext 在标签页上启用 Network 并监听媒体请求
+func (s *Sniffer) attachSnifferToContext(sess *tabSession) error {
+	if err := sess.run(network.Enable()); err != nil {
+		log.Printf("[Target %s] 启用 Network 失败: %v", sess.targetID, err)
+		return err
+	}
+	t := &tabSniffer{
+		s:       s,
+		sess:    sess,
+		pending: make(map[network.RequestID]*sniffCandidate),
+		extra:   make(map[network.RequestID]network.Headers),
+		dedupe:  newSniffDeduper(),
+	}
+	chromedp.ListenTarget(sess.ctx, t.handle)
+	return nil
+}
+
+// handle CDP 事件回调，在 chromedp 的事件循环中执行，不能阻塞
+func (t *tabSniffer) handle(ev interface{}) {
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		t.onRequest(ev)
+	case *network.EventRequestWillBeSentExtraInfo:
+		t.onExtraInfo(ev)
+	case *network.EventResponseReceived:
+		t.onResponse(ev)
+	case *network.EventDataReceived:
+		t.onData(ev)
+	case *network.EventLoadingFinished:
+		t.onFinished(ev)
+	case *network.EventLoadingFailed:
+		t.mu.Lock()
+		delete(t.pending, ev.RequestID)
+		delete(t.extra, ev.RequestID)
+		t.mu.Unlock()
+	}
+}
+
+// onRequest 记录可能是媒体的请求：URL 像媒体、命中规则，或者是 XHR/Fetch/媒体元素发起的请求
+func (t *tabSniffer) onRequest(ev *network.EventRequestWillBeSent) {
+	url, docUrl := ev.Request.URL, ev.DocumentURL
+	if strings.HasPrefix(url, "data:") || strings.HasPrefix(url, "blob:") {
+		return
+	}
+	t.mu.Lock()
+	segment := t.dedupe.claimSegment(url, t.volatile())
+	t.mu.Unlock()
+
+	rule := t.s.matchRule(url, docUrl)
+	if rule != nil && rule.Exclude {
+		segment, rule = true, nil // 命中排除规则，与分片一样直接忽略
+	}
+	urlMatch := t.s.isGenericMediaURL(url) || rule != nil
+	candidate := !segment // 已嗅探播放列表的分片，不作为独立视频上报
+	if candidate && !urlMatch {
+		switch ev.Type {
+		case network.ResourceTypeMedia, network.ResourceTypeXHR, network.ResourceTypeFetch, network.ResourceTypeOther:
+		default:
+			candidate = false
+		}
+	}
+	if !candidate {
+		t.mu.Lock()
+		delete(t.extra, ev.RequestID)
+		t.mu.Unlock()
+		return
+	}
+
+	event := &SniffEvent{
+		Url:        url,
+		OriginUrl:  docUrl,
+		TargetID:   t.sess.targetID,
+		Type:       t.s.getURLType(url),
+		Headers:    t.s.filterHeaders(ev.Request.Headers, rule),
+		Resolution: GuessResolution(url),
+	}
+	if rule != nil {
+		rule = rule.clone()
+		event.RuleName = rule.Name
+		if rewritten := rule.RewriteURL(url); rewritten != url {
+			event.Url, event.SourceUrl = rewritten, url
+			if res := GuessResolution(rewritten); res != "" {
+				event.Resolution = res
+			}
+		}
+		if rule.Type != "" {
+			event.Type = rule.Type
+		}
+	}
+	c := &sniffCandidate{event: event, rule: rule, headers: ev.Request.Headers, method: ev.Request.Method, urlMatch: urlMatch}
+	t.mu.Lock()
+	if extra, ok := t.extra[ev.RequestID]; ok {
+		delete(t.extra, ev.RequestID)
+		t.applyExtraHeaders(c, extra)
+	}
+	t.pending[ev.RequestID] = c
+	t.mu.Unlock()
+}
+
+// onExtraInfo 合并浏览器实际发送的请求头
+// request.headers 中不含 Cookie 等由网络层添加的头，完整内容只在 requestWillBeSentExtraInfo 中，
+// 两个事件的先后顺序不固定
+func (t *tabSniffer) onExtraInfo(ev *network.EventRequestWillBeSentExtraInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.pending[ev.RequestID]; ok {
+		t.applyExtraHeaders(c, ev.Headers)
+		return
+	}
+	t.extra[ev.RequestID] = ev.Headers
+}
+
+// applyExtraHeaders 合并后重新按规则过滤请求头，调用方需持有 t.mu
+func (t *tabSniffer) applyExtraHeaders(c *sniffCandidate, extra network.Headers) {
+	merged := make(network.Headers, len(c.headers)+len(extra))
+	for k, v := range c.headers {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		if strings.HasPrefix(k, ":") {
+			continue // HTTP/2 伪头
+		}
+		for old := range merged {
+			if strings.EqualFold(old, k) {
+				delete(merged, old)
+			}
+		}
+		merged[k] = v
+	}
+	c.headers = merged
+	c.event.Headers = t.s.filterHeaders(merged, c.rule)
+}
+
+// onResponse 记录大小与 Range 支持，并按 Content-Type 判断是否为媒体
+func (t *tabSniffer) onResponse(ev *network.EventResponseReceived) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[ev.RequestID]
+	if !ok {
+		return
+	}
+	event := c.event
+	headers := ev.Response.Headers
+
+	// 判断 Range 支持
+	// 方式 A: 检查 Accept-Ranges 字段
+	if val, ok := headerValue(headers, "Accept-Ranges"); ok && strings.Contains(strings.ToLower(val), "bytes") {
+		event.SupportRange = true
+	}
+	// 方式 B: 如果响应状态码直接就是 206 Partial Content
+	if ev.Response.Status == 206 {
+		event.SupportRange = true
+	}
+
+	// 获取文件总大小：分段响应取 Content-Range 中的总大小，否则取 Content-Length
+	if val, ok := headerValue(headers, "Content-Range"); ok && ev.Response.Status == 206 {
+		event.Size = parseContentRangeTotal(val)
+	} else if val, ok := headerValue(headers, "Content-Length"); ok {
+		fmt.Sscanf(val, "%d", &event.Size)
+	}
+	event.ContentType = ev.Response.MimeType
+	event.StatusCode = int(ev.Response.Status)
+
+	class := classifyMIME(ev.Response.MimeType)
+	switch {
+	case c.rule != nil && c.rule.Type == "hls":
+		// 规则强制指定了类型，不再看 Content-Type 与文件头
+		c.playlist = true
+	case c.rule != nil && c.rule.Type != "":
+		delete(t.pending, ev.RequestID)
+		t.emit(event)
+	case class == mimeNotMedia || class == mimeSegment:
+		// 如缩略图、HTML 错误页等，URL 里带 .mp4 也不是视频
+		delete(t.pending, ev.RequestID)
+	case class == mimeHLS:
+		event.Type = "hls"
+		c.playlist = true
+	case class != mimeAmbiguous:
+		event.Type = typeForMIME(class)
+		delete(t.pending, ev.RequestID)
+		t.emit(event)
+	case event.RuleName != "" && event.Type == "hls":
+		c.playlist = true
+	case event.RuleName != "":
+		// 规则已明确指定，不再检查文件头
+		delete(t.pending, ev.RequestID)
+		t.emit(event)
+	case event.Size > 0 && event.Size <= maxStreamSniff:
+		c.sniffing = true
+		go t.streamHead(ev.RequestID)
+	case c.urlMatch && strings.EqualFold(c.method, "GET"):
+		// 大响应不经 CDP 传回；URL 本身像媒体的 GET 请求可以安全地再请求一次开头
+		c.sniffing, c.probing = true, true
+		rawURL := event.Url
+		if event.SourceUrl != "" {
+			rawURL = event.SourceUrl
+		}
+		go t.probeHead(ev.RequestID, rawURL, forwardHeaders(c.headers))
+	default:
+		// POST 等接口请求重发可能产生副作用，一次性或签名链接也可能被消耗，不单独请求：
+		// 加载完成后在 maxBodyFetch 以内读取完整响应，超出时按 URL 判断
+		c.sniffing, c.fallback = true, true
+	}
+}
+
+// forwardHeaders 复制浏览器请求头用于单独发出的请求
+// HTTP/2 伪头与编码协商不能原样转发，Range 由调用方设置
+func forwardHeaders(reqHeaders network.Headers) map[string]string {
+	headers := make(map[string]string, len(reqHeaders))
+	for k, v := range reqHeaders {
+		if strings.HasPrefix(k, ":") || strings.EqualFold(k, "Range") || strings.EqualFold(k, "Accept-Encoding") {
+			continue
+		}
+		headers[k] = fmt.Sprintf("%v", v)
+	}
+	return headers
+}
+
+// probeHead 单独发 Range 请求读取大响应的开头，避免浏览器把整个响应经 CDP 传回
+// 只用于 URL 像媒体的 GET 请求，读取失败时按 URL 判断
+func (t *tabSniffer) probeHead(id network.RequestID, rawURL string, headers map[string]string) {
+	head, err := fetchHead(rawURL, headers, t.s.settings.Get().PreCheckTimeoutDuration())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		log.Printf("[Target %s] 读取响应开头失败: %v", t.sess.targetID, err)
+	}
+	c.probing = false
+	c.head = head
+	t.resolve(id, c)
+	// 确认是 m3u8 且已加载完成时读取完整内容（未完成的由 onFinished 处理）
+	if c.playlist && c.finished && t.pending[id] == c {
+		go t.fetchBody(id)
+	}
+}
+
+// streamHead 通过 streamResourceContent 取得已缓冲的响应数据，不足时由之后的 dataReceived 补齐
+func (t *tabSniffer) streamHead(id network.RequestID) {
+	var buffered []byte
+	err := t.sess.run(chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		buffered, err = network.StreamResourceContent(id).Do(ctx)
+		return err
+	}))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		// 旧版浏览器不支持，或者响应已经加载完成
+		c.fallback = true
+		if c.finished {
+			go t.fetchBody(id)
+		}
+		return
+	}
+	c.streaming = true
+	c.head = append(buffered, c.streamed...)
+	c.streamed = nil
+	if len(c.head) >= sniffBodyBytes || c.finished {
+		t.resolve(id, c)
+	}
+}
+
+// onData 流式读取开启后，dataReceived 会携带响应数据
+func (t *tabSniffer) onData(ev *network.EventDataReceived) {
+	if ev.Data == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[ev.RequestID]
+	if !ok || !c.sniffing {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(ev.Data)
+	if err != nil {
+		return
+	}
+	if !c.streaming {
+		c.streamed = append(c.streamed, data...)
+		return
+	}
+	c.head = append(c.head, data...)
+	if !c.playlist && len(c.head) >= sniffBodyBytes {
+		t.resolve(ev.RequestID, c)
+	}
+}
+
+// onFinished 响应加载完成：用已读到的数据判断，流式读取不可用时读取完整响应
+func (t *tabSniffer) onFinished(ev *network.EventLoadingFinished) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.extra, ev.RequestID)
+	c, ok := t.pending[ev.RequestID]
+	if !ok {
+		return
+	}
+	c.finished = true
+	switch {
+	case c.playlist && c.streaming:
+		// 流式读取已拿到完整内容
+		t.emitPlaylist(ev.RequestID, c, c.head)
+	case c.playlist:
+		go t.fetchBody(ev.RequestID)
+	case !c.sniffing:
+		// 没有收到响应头的请求（如缓存命中失败）不再处理
+		delete(t.pending, ev.RequestID)
+	case c.probing:
+		// 等待 probeHead 的结果
+	case c.fallback && ev.EncodedDataLength <= maxBodyFetch:
+		go t.fetchBody(ev.RequestID)
+	case c.fallback:
+		t.resolve(ev.RequestID, c)
+	case c.streaming:
+		t.resolve(ev.RequestID, c)
+	}
+}
+
+// fetchBody 读取完整响应用于识别文件头
+func (t *tabSniffer) fetchBody(id network.RequestID) {
+	var body []byte
+	_ = t.sess.run(chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetResponseBody(id).Do(ctx)
+		return err
+	}))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[id]
+	if !ok {
+		return
+	}
+	if c.playlist {
+		t.emitPlaylist(id, c, body)
+		return
+	}
+	c.head = body
+	t.resolve(id, c)
+}
+
+// resolve 根据响应开头的字节决定是否上报，调用方需持有 t.mu
+// m3u8 需要完整内容：已加载完成时直接解析，否则等待 onFinished
+func (t *tabSniffer) resolve(id network.RequestID, c *sniffCandidate) {
+	typ, segment := sniffBodyType(c.head)
+	switch {
+	case segment:
+		delete(t.pending, id)
+		return
+	case typ != "":
+		c.event.Type = typ
+	case !c.urlMatch:
+		delete(t.pending, id)
+		return
+	}
+	if c.event.Type != "hls" {
+		delete(t.pending, id)
+		t.emit(c.event)
+		return
+	}
+	c.playlist = true
+	if c.finished && (c.streaming || c.fallback) {
+		t.emitPlaylist(id, c, c.head)
+	}
+}
+
+// emitPlaylist 用预解析的 m3u8 信息补充事件后上报，调用方需持有 t.mu
+// 解析失败时仍按普通 HLS 上报，由下载时再处理
+func (t *tabSniffer) emitPlaylist(id network.RequestID, c *sniffCandidate, body []byte) {
+	delete(t.pending, id)
+	event := c.event
+	// 相对地址基于实际请求的地址解析（改写前）
+	base := event.Url
+	if event.SourceUrl != "" {
+		base = event.SourceUrl
+	}
+	info, err := ParsePlaylistInfo(body, base)
+	if err != nil {
+		log.Printf("[Target %s] 预解析 m3u8 失败: %v", t.sess.targetID, err)
+		t.emit(event)
+		return
+	}
+	applyPlaylistInfo(event, info)
+	g := t.emit(event)
+	t.dedupe.addPlaylist(g, info, t.volatile())
+}
+
+// emit 去重后延迟获取页面标题并上报给前端，调用方需持有 t.mu
+// 同一媒体的重复请求、分段请求以及 HLS 的其他码率列表只上报第一次；
+// 它们带来的新信息（大小、时长等）合并到首次的事件中，已上报过的再以相同 mediaId 推送一次更新
+func (t *tabSniffer) emit(event *SniffEvent) *mediaGroup {
+	g, isNew := t.dedupe.admit(event, t.volatile())
+	if !isNew {
+		if g.suppressed || !mergeSniffEvent(g.event, event) {
+			return g
+		}
+		// 主列表首次上报时没有时长，由子列表补齐后才能按时长过滤，大小同理
+		emitted := g.event.Title != ""
+		if reason := t.s.filterFor(t.s.GetRule(g.event.RuleName)).check(g.event); reason != "" {
+			t.suppressGroup(g, reason, emitted)
+			return g
+		}
+		if emitted {
+			t.s.manager.emitEvent("video_sniffed", *g.event)
+		}
+		return g
+	}
+	event.MediaID = g.id
+	if reason := t.s.filterFor(t.s.GetRule(event.RuleName)).check(event); reason != "" {
+		t.suppressGroup(g, reason, false)
+		return g
+	}
+	time.AfterFunc(800*time.Millisecond, func() {
+		var title string
+		_ = t.sess.run(chromedp.Title(&title))
+		if title == "" {
+			title = "未知视频"
+		}
+		t.mu.Lock()
+		if g.suppressed {
+			// 等待标题期间补齐的信息使其被过滤
+			t.mu.Unlock()
+			return
+		}
+		event.Title = title
+		snapshot := *event
+		t.mu.Unlock()
+		t.s.manager.emitEvent("video_sniffed", snapshot)
+	})
+	return g
+}
+
+// suppressGroup 过滤一个媒体，记在去重表中，同一媒体的后续请求不再重复计数；调用方需持有 t.mu
+// emitted 为 true 时该媒体已上报过，通知前端移除
+func (t *tabSniffer) suppressGroup(g *mediaGroup, reason string, emitted bool) {
+	g.suppressed = true
+	t.suppressed++
+	t.s.suppress(g.event, reason, emitted, t.suppressed)
+}
+
+// volatile 去重时忽略的查询参数
+func (t *tabSniffer) volatile() []string {
+	return t.s.settings.Get().VolatileQueryParams
+}
+
+// headerValue 不区分大小写读取 CDP 响应头（HTTP/2 下均为小写）
+func headerValue(headers network.Headers, key string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return fmt.Sprintf("%v", v), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsSampleInterval = time.Second      // 速度采样周期
+	speedHistoryLen     = 120              // 每条速度曲线保留的采样点数（2 分钟）
+	statsSaveInterval   = 30 * time.Second // 累计统计落盘周期
+)
+
+// speedRing 固定长度的速度采样环形缓冲
+type speedRing struct {
+	samples [speedHistoryLen]float64
+	next    int
+	count   int
+}
+
+func (r *speedRing) push(bps float64) {
+	r.samples[r.next] = bps
+	r.next = (r.next + 1) % speedHistoryLen
+	if r.count < speedHistoryLen {
+		r.count++
+	}
+}
+
+// values 按时间顺序返回采样（旧 -> 新）
+func (r *speedRing) values() []float64 {
+	out := make([]float64, 0, r.count)
+	start := (r.next - r.count + speedHistoryLen) % speedHistoryLen
+	for i := 0; i < r.count; i++ {
+		out = append(out, r.samples[(start+i)%speedHistoryLen])
+	}
+	return out
+}
+
+// GlobalStats 全局状态栏数据
+type GlobalStats struct {
+	TotalBps     float64   `json:"totalBps"`
+	TotalSpeed   string    `json:"totalSpeed"`
+	Active       int       `json:"active"`    // 下载中、合并中
+	Queued       int       `json:"queued"`    // 等待开始或已暂停
+	Completed    int       `json:"completed"` // 已完成
+	Failed       int       `json:"failed"`
+	BytesToday   int64     `json:"bytesToday"`
+	SpeedHistory []float64 `json:"speedHistory"` // 全局速度采样（字节/秒，旧 -> 新）
+}
+
+// HostStats 按站点累计的下载统计，跨重启保留
+type HostStats struct {
+	Host      string `json:"host"`
+	Bytes     int64  `json:"bytes"`
+	Completed int    `json:"completed"` // 完成的任务数
+	Failed    int    `json:"failed"`    // 失败次数
+	LastUsed  int64  `json:"lastUsed"`  // Unix 毫秒
+}
+
+// statsFile stats.json 的内容
+type statsFile struct {
+	Version    int                   `json:"version"`
+	Day        string                `json:"day"` // BytesToday 对应的日期（本地时间 2006-01-02）
+	BytesToday int64                 `json:"bytesToday"`
+	Hosts      map[string]*HostStats `json:"hosts"`
+}
+
+// StatsStore 累计统计的持久化，写入 exe/stats.json
+// 下载过程中只累加内存计数，由 Manager 定期落盘
+type StatsStore struct {
+	path  string
+	mu    sync.Mutex
+	data  statsFile
+	dirty bool
+}
+
+func NewStatsStore(path string) *StatsStore {
+	s := &StatsStore{path: path}
+	s.data = statsFile{Version: 1, Hosts: make(map[string]*HostStats)}
+	if raw, err := os.ReadFile(path); err == nil {
+		var f statsFile
+		if err := json.Unmarshal(raw, &f); err != nil {
+			log.Printf("统计文件损坏，已重置: %v", err)
+		} else {
+			if f.Hosts == nil {
+				f.Hosts = make(map[string]*HostStats)
+			}
+			s.data = f
+		}
+	}
+	return s
+}
+
+// rollDay 日期变化时清零今日流量，调用方需持有锁
+func (s *StatsStore) rollDay(now time.Time) {
+	if day := now.Format("2006-01-02"); s.data.Day != day {
+		s.data.Day = day
+		s.data.BytesToday = 0
+		s.dirty = true
+	}
+}
+
+func (s *StatsStore) host(name string) *HostStats {
+	h, ok := s.data.Hosts[name]
+	if !ok {
+		h = &HostStats{Host: name}
+		s.data.Hosts[name] = h
+	}
+	return h
+}
+
+// AddBytes 累加某站点的下载字节数
+func (s *StatsStore) AddBytes(host string, n int64) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.rollDay(now)
+	s.data.BytesToday += n
+	h := s.host(host)
+	h.Bytes += n
+	h.LastUsed = now.UnixMilli()
+	s.dirty = true
+}
+
+// AddResult 记录一次任务完成或失败
+func (s *StatsStore) AddResult(host string, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.host(host)
+	if success {
+		h.Completed++
+	} else {
+		h.Failed++
+	}
+	h.LastUsed = time.Now().UnixMilli()
+	s.dirty = true
+}
+
+func (s *StatsStore) BytesToday() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDay(time.Now())
+	return s.data.BytesToday
+}
+
+// Hosts 返回按累计字节数倒序的站点统计副本
+func (s *StatsStore) Hosts() []HostStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]HostStats, 0, len(s.data.Hosts))
+	for _, h := range s.data.Hosts {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// Save 有变更时原子写盘
+func (s *StatsStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// taskHost 任务所属站点，用于按站点统计
+func taskHost(task *VideoTask) string {
+	u, err := url.Parse(task.Url)
+	if err != nil || u.Hostname() == "" {
+		return "unknown"
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// speed 任务当前速度，超过 2 个采样周期没有进度视为停滞，按 0 计算
+func (s *taskSnap) speed() float64 {
+	if time.Since(s.lastTime) > 2*statsSampleInterval {
+		return 0
+	}
+	return s.currBps
+}
+
+// sampleSpeeds 为每个任务和全局各记录一个速度采样，由事件循环每秒调用
+func (m *Manager) sampleSpeeds() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total float64
+	for id, snap := range m.stats {
+		task, ok := m.tasks[id]
+		if !ok || task.Status != "downloading" {
+			continue
+		}
+		bps := snap.speed()
+		snap.history.push(bps)
+		total += bps
+	}
+	m.globalSpeed.push(total)
+}
+
+// GetGlobalStats 返回全局状态栏数据
+func (m *Manager) GetGlobalStats() GlobalStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := GlobalStats{SpeedHistory: m.globalSpeed.values()}
+	for _, task := range m.tasks {
+		switch task.Status {
+		case "downloading", "merging":
+			st.Active++
+			if snap, ok := m.stats[task.ID]; ok {
+				st.TotalBps += snap.speed()
+			}
+		case "done":
+			st.Completed++
+		case "error":
+			st.Failed++
+		default:
+			st.Queued++
+		}
+	}
+	st.TotalSpeed = m.formatSpeed(st.TotalBps)
+	st.BytesToday = m.counters.BytesToday()
+	return st
+}
+
+// GetSpeedHistory 返回任务最近的速度采样（字节/秒，旧 -> 新），任务未在下载时返回空
+func (m *Manager) GetSpeedHistory(id string) []float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if snap, ok := m.stats[id]; ok {
+		return snap.history.values()
+	}
+	return []float64{}
+}
+
+// GetHostStats 返回按站点累计的下载统计
+func (m *Manager) GetHostStats() []HostStats {
+	return m.counters.Hosts()
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TaskSchemaVersion tasks.json 的结构版本
+// v1: 直接序列化 map[id]*VideoTask
+// v2: 外层包裹 {version, tasks}
+const TaskSchemaVersion = 2
+
+const (
+	taskBackupCount    = 3               // 保留的滚动备份数量 tasks.json.1 ~ .3
+	taskBackupInterval = 5 * time.Minute // 两次备份的最小间隔，避免每次写盘都轮转
+)
+
+// taskFile 持久化文件的外层结构
+type taskFile struct {
+	Version int                   `json:"version"`
+	Tasks   map[string]*VideoTask `json:"tasks"`
+}
+
+// taskMigrations[n] 负责把 v(n) 的原始 JSON 升级为 v(n+1)
+var taskMigrations = map[int]func(raw []byte) ([]byte, error){
+	1: func(raw []byte) ([]byte, error) {
+		var tasks map[string]*VideoTask
+		if err := json.Unmarshal(raw, &tasks); err != nil {
+			return nil, err
+		}
+		return json.Marshal(taskFile{Version: 2, Tasks: tasks})
+	},
+}
+
+// detectTaskSchemaVersion 识别原始数据的版本，v1 没有 version 字段
+func detectTaskSchemaVersion(raw []byte) (int, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return 0, err
+	}
+	v, hasVersion := probe["version"]
+	_, hasTasks := probe["tasks"]
+	if !hasVersion || !hasTasks {
+		return 1, nil
+	}
+	var version int
+	if err := json.Unmarshal(v, &version); err != nil {
+		return 0, fmt.Errorf("无效的版本号: %v", err)
+	}
+	return version, nil
+}
+
+// decodeTaskFile 解析任务文件并按需执行迁移
+func decodeTaskFile(raw []byte) (map[string]*VideoTask, error) {
+	version, err := detectTaskSchemaVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	if version > TaskSchemaVersion {
+		return nil, fmt.Errorf("任务文件版本 v%d 高于程序支持的 v%d，请升级程序", version, TaskSchemaVersion)
+	}
+	for ; version < TaskSchemaVersion; version++ {
+		migrate, ok := taskMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("缺少 v%d 的迁移逻辑", version)
+		}
+		if raw, err = migrate(raw); err != nil {
+			return nil, fmt.Errorf("迁移 v%d 失败: %v", version, err)
+		}
+	}
+
+	var file taskFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+	if file.Tasks == nil {
+		file.Tasks = make(map[string]*VideoTask)
+	}
+	return file.Tasks, nil
+}
+
+// encodeTaskFile 序列化为当前版本
+func encodeTaskFile(tasks map[string]*VideoTask) ([]byte, error) {
+	return json.MarshalIndent(taskFile{Version: TaskSchemaVersion, Tasks: tasks}, "", "  ")
+}
+
+// writeFileAtomic 写入临时文件并 fsync 后重命名，保证目标文件要么是旧内容要么是完整的新内容
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// backupPath 第 n 个滚动备份的路径
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups 将当前文件复制为 .1，原有备份依次后移，最旧的被丢弃
+func rotateBackups(path string, count int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// 只备份能正常解析的文件，避免把损坏内容轮转进备份
+	if _, err := decodeTaskFile(data); err != nil {
+		return nil
+	}
+	for i := count - 1; i >= 1; i-- {
+		if _, err := os.Stat(backupPath(path, i)); err == nil {
+			_ = os.Rename(backupPath(path, i), backupPath(path, i+1))
+		}
+	}
+	return writeFileAtomic(backupPath(path, 1), data)
+}
+
+// loadTaskFileWithRecovery 读取任务文件，主文件损坏时依次尝试备份
+// 返回的 issue 非空时表示需要告知用户（已从备份恢复或无法恢复）
+func loadTaskFileWithRecovery(path string, backups int) (tasks map[string]*VideoTask, issue string) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if tasks, err = decodeTaskFile(data); err == nil {
+			return tasks, ""
+		}
+		// 保留损坏文件以便排查，下次写盘不会覆盖它
+		corrupt := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+		_ = os.Rename(path, corrupt)
+		issue = fmt.Sprintf("任务文件解析失败 (%v)，已另存为 %s", err, filepath.Base(corrupt))
+	} else if !os.IsNotExist(err) {
+		issue = fmt.Sprintf("读取任务文件失败: %v", err)
+	}
+
+	for i := 1; i <= backups; i++ {
+		data, err := os.ReadFile(backupPath(path, i))
+		if err != nil {
+			continue
+		}
+		if tasks, err := decodeTaskFile(data); err == nil {
+			if issue == "" {
+				issue = "任务文件缺失"
+			}
+			return tasks, fmt.Sprintf("%s，已从备份 %s 恢复", issue, filepath.Base(backupPath(path, i)))
+		}
+	}
+
+	if issue != "" {
+		issue += "，且没有可用的备份，任务列表为空"
+	}
+	return make(map[string]*VideoTask), issue
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// 可选的持久化后端
+const (
+	StoreJSON    = "json"    // 单个 tasks.json，每次变更整体重写
+	StoreJournal = "journal" // 追加写日志 + 定期压缩快照
+)
+
+// TaskStore 任务持久化后端
+// 任务元数据与单元进度分开写入：元数据变更走 PutTask，单元计划走 PutUnits，
+// 下载过程中高频的单元完成记录走 MarkUnits，后端可以只追加增量而不必重写整个任务
+type TaskStore interface {
+	// Load 读取所有任务；issue 非空表示需要告知用户的问题（如已从备份恢复）
+	Load() (tasks map[string]*VideoTask, issue string, err error)
+	// PutTask 保存任务元数据，实现需忽略 task.InternalState
+	PutTask(task *VideoTask) error
+	// PutUnits 整体替换任务的单元计划（新建、重新解析时）
+	PutUnits(id string, state *TaskInternalState) error
+	// MarkUnits 增量更新若干单元的完成状态
+	MarkUnits(id string, kind UnitKind, indexes []int, finished bool) error
+	// DeleteTask 删除任务及其单元进度
+	DeleteTask(id string) error
+	// Close 落盘并释放资源
+	Close() error
+}
+
+// OpenTaskStore 根据类型在 dir 下打开持久化后端
+func OpenTaskStore(kind, dir string) (TaskStore, error) {
+	switch kind {
+	case StoreJSON, "":
+		return NewJSONTaskStore(filepath.Join(dir, "tasks.json")), nil
+	case StoreJournal:
+		return NewJournalTaskStore(dir)
+	}
+	return nil, fmt.Errorf("未知的存储后端: %s", kind)
+}
+
+// taskTable 后端内部维护的任务副本，供各实现复用增量应用逻辑
+type taskTable map[string]*VideoTask
+
+func (t taskTable) putTask(task *VideoTask) {
+	c := task.Clone()
+	// 单元进度由 PutUnits/MarkUnits 单独维护
+	if old, ok := t[task.ID]; ok {
+		c.InternalState = old.InternalState
+	} else {
+		c.InternalState = nil
+	}
+	t[task.ID] = c
+}
+
+func (t taskTable) putUnits(id string, state *TaskInternalState) bool {
+	task, ok := t[id]
+	if !ok {
+		return false
+	}
+	task.InternalState = state.Clone()
+	return true
+}
+
+func (t taskTable) markUnits(id string, kind UnitKind, indexes []int, finished bool) bool {
+	task, ok := t[id]
+	if !ok || task.InternalState == nil {
+		return false
+	}
+	for _, idx := range indexes {
+		task.InternalState.SetUnitFinished(kind, idx, finished)
+	}
+	return true
+}
+
+// cloneAll 返回所有任务的深拷贝
+func (t taskTable) cloneAll() map[string]*VideoTask {
+	out := make(map[string]*VideoTask, len(t))
+	for id, task := range t {
+		out[id] = task.Clone()
+	}
+	return out
+}
@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalCompactRecords = 1000            // 追加多少条记录后压缩
+	journalCompactBytes   = 8 * 1024 * 1024 // 日志超过多大后压缩
+	journalMaxLineBytes   = 64 * 1024 * 1024
+)
+
+// 日志记录类型
+const (
+	journalOpPut   = "put"   // 任务元数据
+	journalOpUnits = "units" // 整个单元计划
+	journalOpMark  = "mark"  // 单元完成状态增量
+	journalOpDel   = "del"   // 删除任务
+)
+
+// journalRecord 日志中的一行，所有操作都是幂等的，重复回放不影响结果
+type journalRecord struct {
+	Op       string             `json:"op"`
+	ID       string             `json:"id"`
+	Task     *VideoTask         `json:"task,omitempty"`
+	State    *TaskInternalState `json:"state,omitempty"`
+	Kind     UnitKind           `json:"kind,omitempty"`
+	Indexes  []int              `json:"indexes,omitempty"`
+	Finished bool               `json:"finished,omitempty"`
+}
+
+// JournalTaskStore 追加写日志后端
+// 状态 = tasks.snapshot.json 快照 + 回放 tasks.journal 中的增量记录；
+// 单元完成只追加一条很小的 mark 记录，日志过大时压缩为新快照
+type JournalTaskStore struct {
+	snapshotPath string
+	journalPath  string
+	legacyPath   string // 旧的 tasks.json，首次启用时从中导入
+
+	mu         sync.Mutex
+	tasks      taskTable
+	journal    *os.File
+	records    int
+	bytes      int64
+	lastBackup time.Time
+}
+
+func NewJournalTaskStore(dir string) (*JournalTaskStore, error) {
+	return &JournalTaskStore{
+		snapshotPath: filepath.Join(dir, "tasks.snapshot.json"),
+		journalPath:  filepath.Join(dir, "tasks.journal"),
+		legacyPath:   filepath.Join(dir, "tasks.json"),
+		tasks:        make(taskTable),
+	}, nil
+}
+
+func (s *JournalTaskStore) Load() (map[string]*VideoTask, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var issue string
+	if !fileExists(s.snapshotPath) && !fileExists(s.journalPath) && fileExists(s.legacyPath) {
+		// 首次从 JSON 后端切换过来，导入已有任务
+		tasks, legacyIssue := loadTaskFileWithRecovery(s.legacyPath, taskBackupCount)
+		s.tasks = taskTable(tasks)
+		issue = legacyIssue
+		log.Printf("已从 %s 导入 %d 个任务", filepath.Base(s.legacyPath), len(tasks))
+	} else {
+		tasks, snapIssue := loadTaskFileWithRecovery(s.snapshotPath, taskBackupCount)
+		s.tasks = taskTable(tasks)
+		issue = snapIssue
+		if skipped, err := s.replay(); err != nil {
+			issue = joinIssue(issue, fmt.Sprintf("读取任务日志失败: %v", err))
+		} else if skipped > 0 {
+			issue = joinIssue(issue, fmt.Sprintf("任务日志中有 %d 条损坏记录已跳过", skipped))
+		}
+	}
+
+	// 启动时压缩一次：清掉回放过的日志，同时截掉崩溃时写了一半的尾行
+	if err := s.compact(); err != nil {
+		return s.tasks.cloneAll(), issue, err
+	}
+	return s.tasks.cloneAll(), issue, nil
+}
+
+// replay 逐行回放日志，返回跳过的损坏行数
+// 最后一行损坏通常是崩溃时写了一半，属于正常情况，不计入
+func (s *JournalTaskStore) replay() (int, error) {
+	f, err := os.Open(s.journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	skipped, tailBad := 0, false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), journalMaxLineBytes)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if tailBad {
+			skipped++
+			tailBad = false
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			tailBad = true
+			continue
+		}
+		s.apply(rec)
+	}
+	return skipped, scanner.Err()
+}
+
+func (s *JournalTaskStore) apply(rec journalRecord) {
+	switch rec.Op {
+	case journalOpPut:
+		if rec.Task != nil {
+			s.tasks.putTask(rec.Task)
+		}
+	case journalOpUnits:
+		s.tasks.putUnits(rec.ID, rec.State)
+	case journalOpMark:
+		s.tasks.markUnits(rec.ID, rec.Kind, rec.Indexes, rec.Finished)
+	case journalOpDel:
+		delete(s.tasks, rec.ID)
+	}
+}
+
+func (s *JournalTaskStore) PutTask(task *VideoTask) error {
+	meta := *task
+	meta.InternalState = nil
+	return s.append(journalRecord{Op: journalOpPut, ID: task.ID, Task: &meta})
+}
+
+func (s *JournalTaskStore) PutUnits(id string, state *TaskInternalState) error {
+	return s.append(journalRecord{Op: journalOpUnits, ID: id, State: state})
+}
+
+func (s *JournalTaskStore) MarkUnits(id string, kind UnitKind, indexes []int, finished bool) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	return s.append(journalRecord{Op: journalOpMark, ID: id, Kind: kind, Indexes: indexes, Finished: finished})
+}
+
+func (s *JournalTaskStore) DeleteTask(id string) error {
+	return s.append(journalRecord{Op: journalOpDel, ID: id})
+}
+
+func (s *JournalTaskStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	err := s.compact()
+	if closeErr := s.journal.Close(); err == nil {
+		err = closeErr
+	}
+	s.journal = nil
+	return err
+}
+
+// append 先更新内存副本再追加写日志，达到阈值时压缩
+func (s *JournalTaskStore) append(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apply(rec)
+	if s.journal == nil {
+		if err := s.openJournal(); err != nil {
+			return err
+		}
+	}
+	n, err := s.journal.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	if err := s.journal.Sync(); err != nil {
+		return err
+	}
+
+	s.records++
+	s.bytes += int64(n)
+	if s.records >= journalCompactRecords || s.bytes >= journalCompactBytes {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact 把内存状态写成新快照并清空日志，调用方需持有锁
+// 快照写入后、日志截断前崩溃也没关系：回放幂等记录只会得到同样的结果
+func (s *JournalTaskStore) compact() error {
+	data, err := encodeTaskFile(s.tasks)
+	if err != nil {
+		return err
+	}
+	if time.Since(s.lastBackup) >= taskBackupInterval {
+		_ = rotateBackups(s.snapshotPath, taskBackupCount)
+		s.lastBackup = time.Now()
+	}
+	if err := writeFileAtomic(s.snapshotPath, data); err != nil {
+		return err
+	}
+
+	if s.journal == nil {
+		if err := s.openJournal(); err != nil {
+			return err
+		}
+	}
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	s.records = 0
+	s.bytes = 0
+	return nil
+}
+
+func (s *JournalTaskStore) openJournal() error {
+	f, err := os.OpenFile(s.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.journal = f
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func joinIssue(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "；" + b
+}
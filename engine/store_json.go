@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// JSONTaskStore 将所有任务整体写入单个 tasks.json
+// 每次变更都会重写整个文件（原子写 + 滚动备份），适合任务量较小的场景
+type JSONTaskStore struct {
+	path       string
+	mu         sync.Mutex
+	tasks      taskTable
+	lastBackup time.Time
+}
+
+func NewJSONTaskStore(path string) *JSONTaskStore {
+	return &JSONTaskStore{
+		path:  path,
+		tasks: make(taskTable),
+	}
+}
+
+func (s *JSONTaskStore) Load() (map[string]*VideoTask, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks, issue := loadTaskFileWithRecovery(s.path, taskBackupCount)
+	s.tasks = taskTable(tasks)
+	if issue != "" {
+		// 恢复后立即以当前版本写回，避免下次启动重复恢复
+		if err := s.flush(); err != nil {
+			return s.tasks.cloneAll(), issue, err
+		}
+	}
+	return s.tasks.cloneAll(), issue, nil
+}
+
+func (s *JSONTaskStore) PutTask(task *VideoTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks.putTask(task)
+	return s.flush()
+}
+
+func (s *JSONTaskStore) PutUnits(id string, state *TaskInternalState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tasks.putUnits(id, state) {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *JSONTaskStore) MarkUnits(id string, kind UnitKind, indexes []int, finished bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tasks.markUnits(id, kind, indexes, finished) {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *JSONTaskStore) DeleteTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, id)
+	return s.flush()
+}
+
+func (s *JSONTaskStore) Close() error {
+	return nil
+}
+
+// flush 原子重写整个文件，调用方需持有锁
+func (s *JSONTaskStore) flush() error {
+	data, err := encodeTaskFile(s.tasks)
+	if err != nil {
+		return err
+	}
+	if time.Since(s.lastBackup) >= taskBackupInterval {
+		// 备份失败不影响主文件写入
+		_ = rotateBackups(s.path, taskBackupCount)
+		s.lastBackup = time.Now()
+	}
+	return writeFileAtomic(s.path, data)
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTaskFileVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"v1 直接是任务表", `{"a":{"id":"a","title":"旧任务"}}`, []string{"a"}, false},
+		{"v1 空表", `{}`, nil, false},
+		{"v2", `{"version":2,"tasks":{"b":{"id":"b"}}}`, []string{"b"}, false},
+		{"v2 tasks 为空", `{"version":2,"tasks":null}`, nil, false},
+		{"版本高于程序", `{"version":99,"tasks":{}}`, nil, true},
+		{"无效的版本号", `{"version":"x","tasks":{}}`, nil, true},
+		{"不是 JSON", `not json`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := decodeTaskFile([]byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(tasks) != len(tt.wantIDs) {
+				t.Fatalf("got %d tasks, want %d", len(tasks), len(tt.wantIDs))
+			}
+			for _, id := range tt.wantIDs {
+				if tasks[id] == nil || tasks[id].ID != id {
+					t.Errorf("task %s missing: %+v", id, tasks)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTaskFileMigrationKeepsFields(t *testing.T) {
+	raw := `{"a":{"id":"a","title":"旧任务","status":"paused","internalState":{"mp4Chunks":[{"index":0,"start":0,"end":9,"isFinished":true}]}}}`
+	tasks, err := decodeTaskFile([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := tasks["a"]
+	if a.Title != "旧任务" || a.Status != "paused" {
+		t.Errorf("metadata lost in migration: %+v", a)
+	}
+	if a.InternalState == nil || len(a.InternalState.MP4Chunks) != 1 || !a.InternalState.MP4Chunks[0].IsFinished {
+		t.Errorf("units lost in migration: %+v", a.InternalState)
+	}
+}
+
+func journalTestTask(id string) *VideoTask {
+	return &VideoTask{ID: id, Title: "task " + id, Type: "hls", Status: "sniffed"}
+}
+
+func openJournal(t *testing.T, dir string) (*JournalTaskStore, map[string]*VideoTask, string) {
+	t.Helper()
+	s, err := NewJournalTaskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, issue, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, tasks, issue
+}
+
+// 模拟崩溃：不调用 Close，日志中的增量记录由下一次 Load 回放
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, _, _ := openJournal(t, dir)
+	t.Cleanup(func() { s.journal.Close() })
+
+	state := &TaskInternalState{HLSSegments: []HLSSegmentState{{Index: 0}, {Index: 1}, {Index: 2}}}
+	steps := []error{
+		s.PutTask(journalTestTask("a")),
+		s.PutTask(journalTestTask("b")),
+		s.PutUnits("a", state),
+		s.MarkUnits("a", UnitHLSSegment, []int{0, 2}, true),
+		s.MarkUnits("a", UnitHLSSegment, []int{2}, false),
+		s.PutTask(&VideoTask{ID: "a", Title: "renamed", Status: "paused"}),
+		s.DeleteTask("b"),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	s2, tasks, issue := openJournal(t, dir)
+	defer s2.Close()
+	if issue != "" {
+		t.Errorf("unexpected issue: %s", issue)
+	}
+	if len(tasks) != 1 || tasks["b"] != nil {
+		t.Fatalf("deleted task replayed: %v", tasks)
+	}
+	a := tasks["a"]
+	if a.Title != "renamed" || a.Status != "paused" {
+		t.Errorf("metadata not replayed: %+v", a)
+	}
+	// PutTask 不应覆盖单元进度
+	if a.InternalState == nil {
+		t.Fatal("units lost after metadata update")
+	}
+	got := []bool{}
+	for _, seg := range a.InternalState.HLSSegments {
+		got = append(got, seg.IsFinished)
+	}
+	if want := []bool{true, false, false}; !slices.Equal(got, want) {
+		t.Errorf("segments finished = %v, want %v", got, want)
+	}
+}
+
+func TestJournalCorruptLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		tail      string
+		wantIssue bool
+	}{
+		{"尾行写了一半", `{"op":"put","id":"x","task":{"id":`, false},
+		{"中间行损坏", "garbage\n" + `{"op":"del","id":"none"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _, _ := openJournal(t, dir)
+			if err := s.PutTask(journalTestTask("a")); err != nil {
+				t.Fatal(err)
+			}
+			s.journal.Close()
+
+			f, err := os.OpenFile(filepath.Join(dir, "tasks.journal"), os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			s2, tasks, issue := openJournal(t, dir)
+			defer s2.Close()
+			if tasks["a"] == nil {
+				t.Errorf("valid record lost: %v", tasks)
+			}
+			if (issue != "") != tt.wantIssue {
+				t.Errorf("issue = %q, wantIssue %v", issue, tt.wantIssue)
+			}
+		})
+	}
+}
+
+func TestJournalImportsLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"a":{"id":"a","title":"legacy"}}`
+	if err := os.WriteFile(filepath.Join(dir, "tasks.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, tasks, _ := openJournal(t, dir)
+	defer s.Close()
+	if tasks["a"] == nil || tasks["a"].Title != "legacy" {
+		t.Fatalf("legacy tasks not imported: %v", tasks)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "tasks.snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"version": 2`) {
+		t.Errorf("snapshot not written in current version: %s", data)
+	}
+}
+
+func TestJSONStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	s := NewJSONTaskStore(path)
+	if _, _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	s.PutTask(journalTestTask("a"))
+	s.PutUnits("a", &TaskInternalState{MP4Chunks: []MP4ChunkState{{Index: 0}, {Index: 1}}})
+	s.MarkUnits("a", UnitMP4Chunk, []int{1}, true)
+	s.PutTask(&VideoTask{ID: "a", Title: "updated"})
+
+	tasks, issue, err := NewJSONTaskStore(path).Load()
+	if err != nil || issue != "" {
+		t.Fatalf("load: %v %q", err, issue)
+	}
+	a := tasks["a"]
+	if a == nil || a.Title != "updated" {
+		t.Fatalf("task not persisted: %+v", a)
+	}
+	if finished, total := a.InternalState.UnitProgress(); finished != 1 || total != 2 {
+		t.Errorf("units = %d/%d, want 1/2", finished, total)
+	}
+}
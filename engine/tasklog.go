@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTaskLogEntries 每个任务保留的日志条数，超出后丢弃最早的
+const maxTaskLogEntries = 1000
+
+// 日志级别
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// TaskLogEntry 任务活动日志的一条记录
+type TaskLogEntry struct {
+	Time    int64  `json:"time"` // Unix 毫秒
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// TaskLogBook 按任务保存的环形日志，只保存在内存中，程序重启后清空
+type TaskLogBook struct {
+	mu   sync.Mutex
+	logs map[string]*taskLogRing
+}
+
+type taskLogRing struct {
+	entries []TaskLogEntry
+	next    int // 环满后下一条写入的位置
+	dropped int // 被覆盖的条数
+}
+
+func NewTaskLogBook() *TaskLogBook {
+	return &TaskLogBook{logs: make(map[string]*taskLogRing)}
+}
+
+func (b *TaskLogBook) Append(taskID string, entry TaskLogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ring, ok := b.logs[taskID]
+	if !ok {
+		ring = &taskLogRing{}
+		b.logs[taskID] = ring
+	}
+	if len(ring.entries) < maxTaskLogEntries {
+		ring.entries = append(ring.entries, entry)
+		return
+	}
+	ring.entries[ring.next] = entry
+	ring.next = (ring.next + 1) % maxTaskLogEntries
+	ring.dropped++
+}
+
+// Entries 按时间顺序返回日志副本，以及因超出容量被丢弃的条数
+func (b *TaskLogBook) Entries(taskID string) ([]TaskLogEntry, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ring, ok := b.logs[taskID]
+	if !ok {
+		return []TaskLogEntry{}, 0
+	}
+	out := make([]TaskLogEntry, 0, len(ring.entries))
+	out = append(out, ring.entries[ring.next:]...)
+	out = append(out, ring.entries[:ring.next]...)
+	return out, ring.dropped
+}
+
+func (b *TaskLogBook) Delete(taskID string) {
+	b.mu.Lock()
+	delete(b.logs, taskID)
+	b.mu.Unlock()
+}
+
+// sensitiveHeaders 日志与导出中需要打码的请求头（小写）
+var sensitiveHeaders = map[string]bool{
+	"cookie":              true,
+	"set-cookie":          true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"x-auth-token":        true,
+	"x-api-key":           true,
+	"x-csrf-token":        true,
+}
+
+// IsSensitiveHeader 判断请求头是否可能包含凭据
+func IsSensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	return sensitiveHeaders[lower] || strings.Contains(lower, "token") || strings.Contains(lower, "secret")
+}
+
+// RedactHeaders 返回打码后的请求头副本，敏感值只保留长度信息
+func RedactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if IsSensitiveHeader(k) {
+			out[k] = fmt.Sprintf("<redacted %d bytes>", len(v))
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// FormatRequestForLog 把请求格式化为一行日志（敏感头已打码）
+func FormatRequestForLog(req *http.Request) string {
+	headers := make(map[string]string, len(req.Header))
+	for k := range req.Header {
+		headers[k] = req.Header.Get(k)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s", req.Method, req.URL.String())
+	for k, v := range RedactHeaders(headers) {
+		fmt.Fprintf(&sb, " | %s: %s", k, v)
+	}
+	return sb.String()
+}
+
+// Logf 向任务的活动日志追加一条记录
+func (m *Manager) Logf(id, level, format string, args ...interface{}) {
+	m.logs.Append(id, TaskLogEntry{
+		Time:    time.Now().UnixMilli(),
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// GetTaskLog 返回任务的活动日志（按时间顺序）
+func (m *Manager) GetTaskLog(id string) []TaskLogEntry {
+	entries, _ := m.logs.Entries(id)
+	return entries
+}
+
+// TaskLogExport 导出给用户附在问题反馈中的诊断包
+type TaskLogExport struct {
+	ExportedAt int64          `json:"exportedAt"`
+	Task       *VideoTask     `json:"task"`
+	Dropped    int            `json:"dropped"` // 超出容量被丢弃的早期日志条数
+	Log        []TaskLogEntry `json:"log"`
+}
+
+// ExportTaskLog 将任务快照（请求头已打码）与活动日志打包为 JSON
+func (m *Manager) ExportTaskLog(id string) ([]byte, error) {
+	task := m.GetTaskByID(id)
+	if task == nil {
+		return nil, fmt.Errorf("任务不存在")
+	}
+	task.Headers = RedactHeaders(task.Headers)
+	entries, dropped := m.logs.Entries(id)
+	return json.MarshalIndent(TaskLogExport{
+		ExportedAt: time.Now().UnixMilli(),
+		Task:       task,
+		Dropped:    dropped,
+		Log:        entries,
+	}, "", "  ")
+}
@@ -8,7 +8,7 @@ import {
 import { EventsOn } from '../wailsjs/runtime';
 import {
     GetTasks, StartBrowser, OpenDownloadFolder,
-    SetExpanded, TogglePin, QuitApp
+    SetExpanded, TogglePin, QuitApp, GetStorageIssue, GetSnifferState
 } from '../wailsjs/go/main/App';
 import { useStore } from './store/useStore';
 
@@ -19,20 +19,32 @@ import MarkingPage from './components/MarkingPage';
 export default function App() {
     const {
         tasks, sniffedMap, activeTargetId, activeTab, isExpanded,
-        setTasks, updateTask, addSniffedItem, setActiveTarget,
-        removeTab, setTab
+        setTasks, applyTaskDelta, applyProgressBatch, addSniffedItem, setActiveTarget,
+        removeTab, setTab, setSuppressed, removeSniffedItem
     } = useStore();
 
     const [isPinned, setIsPinned] = useState(true);
     const [showQuitModal, setShowQuitModal] = useState(false);
+    const [storageIssue, setStorageIssue] = useState('');
+    const [globalStats, setGlobalStats] = useState<any>(null);
+    const [snifferState, setSnifferState] = useState<any>(null);
 
     useEffect(() => {
         EventsOn("video_sniffed", (item: any) => addSniffedItem(item));
+        EventsOn("sniff_suppressed", (ev: any) => {
+            setSuppressed(ev.targetId, ev.count);
+            if (ev.retracted) removeSniffedItem(ev.targetId, ev.mediaId);
+        });
         EventsOn("tab_focused", (tId: string) => setActiveTarget(tId));
         EventsOn("tab_closed", (tId: string) => removeTab(tId));
-        EventsOn("task_list_updated", (list: any[]) => setTasks(list));
-        EventsOn("task_progress", (task: any) => updateTask(task));
+        EventsOn("task_delta", (delta: any) => applyTaskDelta(delta));
+        EventsOn("task_progress_batch", (batch: any[]) => applyProgressBatch(batch));
+        EventsOn("storage_error", (msg: string) => setStorageIssue(msg));
+        EventsOn("global_stats", (st: any) => setGlobalStats(st));
+        EventsOn("sniffer_state", (st: any) => setSnifferState(st));
         GetTasks().then(setTasks);
+        GetSnifferState().then(setSnifferState);
+        GetStorageIssue().then(msg => msg && setStorageIssue(msg));
     }, []);
 
     useEffect(() => { SetExpanded(isExpanded); }, [isExpanded]);
@@ -40,6 +52,13 @@ export default function App() {
     const sniffCount = activeTargetId ? (sniffedMap[activeTargetId]?.length || 0) : 0;
     const downloadCount = tasks.filter(t => t.status !== 'done' && t.status !== 'error').length;
 
+    const snifferTitle: Record<string, string> = {
+        connecting: '正在连接浏览器...',
+        connected: `嗅探中（${snifferState?.tabs ?? 0} 个标签页）`,
+        reconnecting: `正在重连：${snifferState?.message || ''}`,
+    };
+    const browserTitle = snifferTitle[snifferState?.state] || (snifferState?.message ? `打开浏览器（${snifferState.message}）` : '打开浏览器');
+
     const handlePin = async () => setIsPinned(await TogglePin());
 
     // --- 顶部按钮组件 ---
@@ -116,18 +135,44 @@ export default function App() {
 
                         {/* 右侧按钮组 */}
                         <div style={{ display: 'flex', gap: 2 }}>
-                            <HeaderBtn icon={IconBrowser} title="打开浏览器" onClick={()=>StartBrowser()} />
+                            <HeaderBtn icon={IconBrowser} title={browserTitle} highlight={snifferState?.state === 'connected'} onClick={()=>StartBrowser()} />
                             <HeaderBtn icon={IconFolder} title="打开文件夹" onClick={()=>OpenDownloadFolder()} />
                             <HeaderBtn icon={IconPin} title={isPinned?"取消置顶":"置顶"} highlight={isPinned} onClick={handlePin} />
                             <HeaderBtn icon={IconX} title="关闭" onClick={() => setShowQuitModal(true)} />
                         </div>
                     </div>
 
+                    {/* 任务文件异常提示 */}
+                    {storageIssue && (
+                        <div style={{
+                            display: 'flex', alignItems: 'center', gap: 8, padding: '6px 10px',
+                            background: '#fdf3f4', borderBottom: '1px solid #f1c9cc', fontSize: 12, color: '#a4262c'
+                        }}>
+                            <IconAlertCircle size={16} style={{ flexShrink: 0 }} />
+                            <span style={{ flex: 1 }}>{storageIssue}</span>
+                            <ActionIcon size="sm" variant="subtle" color="red" onClick={() => setStorageIssue('')}>
+                                <IconX size={14} />
+                            </ActionIcon>
+                        </div>
+                    )}
+
                     {/* 列表内容区 */}
                     <div style={{ flex: 1, overflow: 'hidden', position: 'relative', background: '#ffffff' }}>
                         {activeTab === 'sniffed' && <SniffedList />}
                         {(activeTab === 'active' || activeTab === 'done') && <DownloadList type={activeTab} />}
                     </div>
+
+                    {/* 底部全局状态栏 */}
+                    {globalStats && (
+                        <div style={{
+                            display: 'flex', justifyContent: 'space-between', padding: '4px 10px',
+                            background: '#f3f3f3', borderTop: '1px solid #e0e0e0', fontSize: 11, color: '#666'
+                        }}>
+                            <span>{globalStats.active > 0 ? globalStats.totalSpeed : '空闲'}</span>
+                            <span>下载中 {globalStats.active} · 等待 {globalStats.queued} · 完成 {globalStats.completed}</span>
+                            <span>今日 {(globalStats.bytesToday / 1024 / 1024).toFixed(1)} MB</span>
+                        </div>
+                    )}
                 </div>
 
                 {/* 右侧扩展区 */}
@@ -2,11 +2,11 @@ import React from 'react';
 import { ScrollArea, Text, Progress, ActionIcon, Menu } from '@mantine/core';
 import {
     IconMovie, IconPlayerPlay, IconPlayerPause,
-    IconTrash, IconRefresh, IconFile, IconDots
+    IconTrash, IconRefresh, IconFile, IconDots, IconFileExport
 } from '@tabler/icons-react';
 import { modals } from '@mantine/modals';
 import { useStore } from '../store/useStore';
-import { StartDownload, StopDownload, DeleteTask } from '../../wailsjs/go/main/App';
+import { StartDownload, StopDownload, DeleteTask, ExportTaskLog } from '../../wailsjs/go/main/App';
 
 export default function DownloadList({ type }: { type: 'active' | 'done' }) {
     const { tasks, setTab, setRebindingTask } = useStore();
@@ -59,14 +59,16 @@ export default function DownloadList({ type }: { type: 'active' | 'done' }) {
                                         <span>
                                             {task.status === 'downloading' ? (
                                                 <span style={{color:'#0078d4'}}>{task.speed}</span>
+                                            ) : task.status === 'error' && task.error ? (
+                                                <span style={{color:'red'}} title={task.error.message}>{task.error.code}: {task.error.message}</span>
                                             ) : (
                                                 <span style={{textTransform:'capitalize'}}>{task.status}</span>
                                             )}
                                         </span>
-                                        <span>{formatSize(task.downloaded)} / {formatSize(task.size)}</span>
+                                        <span>{formatSize(task.downloaded)} / {task.sizeEstimated ? '≈' : ''}{formatSize(task.size)}</span>
                                     </div>
                                     <Progress
-                                        value={task.size > 0 ? (task.downloaded/task.size)*100 : 0}
+                                        value={task.progress || 0}
                                         color={task.status==='error'?'red':'#0078d4'}
                                         size="sm"
                                         radius="xl"
@@ -104,6 +106,9 @@ export default function DownloadList({ type }: { type: 'active' | 'done' }) {
                                             重新捕获链接
                                         </Menu.Item>
                                     )}
+                                    <Menu.Item leftSection={<IconFileExport size={14}/>} onClick={()=>ExportTaskLog(task.id)}>
+                                        导出日志
+                                    </Menu.Item>
                                     <Menu.Item color="red" leftSection={<IconTrash size={14}/>}
                                                onClick={() => modals.openConfirmModal({
                                                    title:'删除任务', children:'确认删除？文件也将被清理。',
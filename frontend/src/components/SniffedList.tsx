@@ -4,11 +4,20 @@ import { IconMovie, IconPlayerPlay, IconPlayerStop, IconScissors, IconDownload }
 import { useStore } from '../store/useStore';
 import { CreateDownloadTask, StartDownload } from '../../wailsjs/go/main/App';
 
+// formatDuration 秒数格式化为 h:mm:ss 或 m:ss
+const formatDuration = (sec: number) => {
+    const total = Math.round(sec);
+    const h = Math.floor(total / 3600), m = Math.floor(total % 3600 / 60), s = total % 60;
+    const pad = (n: number) => String(n).padStart(2, '0');
+    return h > 0 ? `${h}:${pad(m)}:${pad(s)}` : `${m}:${pad(s)}`;
+};
+
 export default function SniffedList() {
-    const { sniffedMap, activeTargetId, setExpanded, setMarkingTask, setTab } = useStore();
+    const { sniffedMap, suppressedMap, activeTargetId, setExpanded, setMarkingTask, setTab } = useStore();
     const [previewIdx, setPreviewIdx] = useState<number | null>(null);
 
     const items = activeTargetId ? (sniffedMap[activeTargetId] || []) : [];
+    const suppressed = activeTargetId ? (suppressedMap[activeTargetId] || 0) : 0;
 
     // 文件名处理
     const getCleanName = (urlStr: string) => {
@@ -40,6 +49,11 @@ export default function SniffedList() {
     return (
         <ScrollArea h="100%" scrollbars="y">
             <div style={{ padding: '0' }}>
+                {suppressed > 0 && (
+                    <div style={{padding:'4px 14px', fontSize:12, color:'#888', borderBottom:'1px solid #f0f0f0'}}>
+                        已过滤 {suppressed} 个广告、预览或无效资源
+                    </div>
+                )}
                 {items.length === 0 && (
                     <div style={{padding:40, textAlign:'center', color:'#888', fontSize:13}}>
                         当前页面未检测到视频
@@ -78,9 +92,20 @@ export default function SniffedList() {
                                         <Badge size="xs" color="gray" radius="sm" variant="light">
                                             {item.type}
                                         </Badge>
-                                        <Text size="xs" c="dimmed">
-                                            {item.size > 0 ? (item.size/1024/1024).toFixed(1) + ' MB' : '未知大小'}
-                                        </Text>
+                                        {item.type === 'hls' ? (
+                                            <Text size="xs" c="dimmed">
+                                                {[
+                                                    item.resolution,
+                                                    item.isLive ? '直播' : (item.duration > 0 ? formatDuration(item.duration) : ''),
+                                                    item.variants?.length > 1 ? `${item.variants.length} 种码率` : '',
+                                                    item.encryption ? `加密 ${item.encryption}` : '',
+                                                ].filter(Boolean).join(' · ') || '未知时长'}
+                                            </Text>
+                                        ) : (
+                                            <Text size="xs" c="dimmed">
+                                                {item.size > 0 ? (item.size/1024/1024).toFixed(1) + ' MB' : '未知大小'}
+                                            </Text>
+                                        )}
                                     </div>
                                 </div>
 
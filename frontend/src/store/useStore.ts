@@ -1,6 +1,16 @@
 import { create } from 'zustand';
 import { engine } from '../../wailsjs/go/models';
 
+// 后端 task_delta 事件：任务列表增量
+interface TaskDelta {
+    added: engine.VideoTask[];
+    updated: engine.VideoTask[];
+    removed: string[];
+}
+
+// 后端 task_progress_batch 事件中单个任务的进度
+type TaskProgress = Pick<engine.VideoTask, 'id' | 'status' | 'progress' | 'downloaded' | 'size' | 'speed' | 'remainingSeconds'>;
+
 // 定义 Zustand 存储的状态结构
 interface TaskState {
     // 核心数据
@@ -17,7 +27,14 @@ interface TaskState {
     // Actions
     setTasks: (tasks: engine.VideoTask[]) => void;
     updateTask: (task: engine.VideoTask) => void;
+    applyTaskDelta: (delta: TaskDelta) => void;
+    applyProgressBatch: (batch: TaskProgress[]) => void;
     addSniffedItem: (item: engine.SniffEvent) => void;
+    // 已上报的结果在补齐信息后被过滤时移除
+    removeSniffedItem: (targetId: string, mediaId: string) => void;
+    // 每个标签页被过滤（广告、预览等）的嗅探结果数
+    suppressedMap: Record<string, number>;
+    setSuppressed: (targetId: string, count: number) => void;
     setActiveTarget: (targetId: string) => void;
     removeTab: (targetId: string) => void;
 
@@ -34,6 +51,7 @@ interface TaskState {
 export const useStore = create<TaskState>((set) => ({
     tasks: [],
     sniffedMap: {},
+    suppressedMap: {},
     activeTargetId: null,
     activeTab: 'sniffed',
     isExpanded: false,
@@ -46,6 +64,27 @@ export const useStore = create<TaskState>((set) => ({
         tasks: state.tasks.map(t => t.id === updatedTask.id ? updatedTask : t)
     })),
 
+    // 增量更新：先删除，再新增（同 ID 则替换），最后更新；新任务排在前面
+    applyTaskDelta: (delta) => set((state) => {
+        const removed = new Set([...(delta.removed || []), ...(delta.added || []).map(t => t.id)]);
+        const updated = new Map((delta.updated || []).map(t => [t.id, t]));
+        const kept = state.tasks
+            .filter(t => !removed.has(t.id))
+            .map(t => updated.get(t.id) || t);
+        return { tasks: [...(delta.added || []), ...kept] };
+    }),
+
+    // 合并进度：只覆盖进度相关字段
+    applyProgressBatch: (batch) => set((state) => {
+        const byId = new Map(batch.map(p => [p.id, p]));
+        return {
+            tasks: state.tasks.map(t => {
+                const p = byId.get(t.id);
+                return p ? { ...t, ...p } as engine.VideoTask : t;
+            })
+        };
+    }),
+
     setMarkingTask: (task) => set({ markingTask: task }),
     setRebindingTask: (task: engine.VideoTask | null) => set({ rebindingTask: task }),
 
@@ -53,8 +92,14 @@ export const useStore = create<TaskState>((set) => ({
         const targetId = item.targetId;
         const existing = state.sniffedMap[targetId] || [];
 
-        // URL 去重
-        if (existing.some(i => i.url === item.url)) return state;
+        // 后端已按逻辑媒体去重，相同媒体 ID 的事件是对已有条目的更新（如补充了时长）
+        const idx = existing.findIndex(i => (item.mediaId ? i.mediaId === item.mediaId : i.url === item.url));
+        if (idx >= 0) {
+            if (!item.mediaId) return state;
+            const list = [...existing];
+            list[idx] = item;
+            return { sniffedMap: { ...state.sniffedMap, [targetId]: list } };
+        }
 
         return {
             sniffedMap: {
@@ -64,12 +109,26 @@ export const useStore = create<TaskState>((set) => ({
         };
     }),
 
+    removeSniffedItem: (targetId, mediaId) => set((state) => {
+        const existing = state.sniffedMap[targetId];
+        if (!existing) return state;
+        return {
+            sniffedMap: { ...state.sniffedMap, [targetId]: existing.filter(i => i.mediaId !== mediaId) }
+        };
+    }),
+
     setActiveTarget: (targetId) => set({ activeTargetId: targetId }),
 
+    setSuppressed: (targetId, count) => set((state) => ({
+        suppressedMap: { ...state.suppressedMap, [targetId]: count }
+    })),
+
     removeTab: (targetId) => set((state) => {
         const newMap = { ...state.sniffedMap };
         delete newMap[targetId];
-        return { sniffedMap: newMap };
+        const suppressed = { ...state.suppressedMap };
+        delete suppressed[targetId];
+        return { sniffedMap: newMap, suppressedMap: suppressed };
     }),
 
     setTab: (tab) => set({ activeTab: tab }),